@@ -0,0 +1,329 @@
+package auth
+
+import (
+	"Mine-Cube/logger"
+	httputil "Mine-Cube/utils/http"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+var log = logger.GetLogger("auth")
+
+type Role string
+
+const (
+	RoleAdmin    Role = "admin"
+	RoleOperator Role = "operator"
+	RoleReadOnly Role = "read-only"
+	// RoleService is held by the manager when it calls workers
+	RoleService Role = "service"
+	// RoleWorker may only register workers and renew their certificates
+	RoleWorker Role = "worker"
+)
+
+type Verb string
+
+const (
+	VerbGet    Verb = "get"
+	VerbList   Verb = "list"
+	VerbCreate Verb = "create"
+	VerbUpdate Verb = "update"
+	VerbDelete Verb = "delete"
+	// VerbExec is granted separately from the other task verbs, since it
+	// gives a shell inside the task's container
+	VerbExec Verb = "exec"
+)
+
+type Resource string
+
+const (
+	ResourceTasks      Resource = "tasks"
+	ResourceLogs       Resource = "logs"
+	ResourceEvents     Resource = "events"
+	ResourceNamespaces Resource = "namespaces"
+	ResourceStats      Resource = "stats"
+	ResourceWorkers    Resource = "workers"
+	// ResourceSecrets covers secret metadata and setting values; values
+	// are never readable through the API
+	ResourceSecrets  Resource = "secrets"
+	ResourceConfigs  Resource = "configs"
+	ResourceVolumes  Resource = "volumes"
+	ResourceNetworks Resource = "networks"
+	ResourceServices Resource = "services"
+)
+
+// Wildcard matches every resource or verb in a Rule.
+const Wildcard = "*"
+
+// Rule grants the listed verbs on the listed resources.
+type Rule struct {
+	Resources []string
+	Verbs     []string
+}
+
+var DefaultRoles = map[Role][]Rule{
+	RoleAdmin: {
+		{Resources: []string{Wildcard}, Verbs: []string{Wildcard}},
+	},
+	RoleOperator: {
+		{Resources: []string{"tasks", "secrets", "configs", "volumes", "services"}, Verbs: []string{"get", "list", "create", "update", "delete"}},
+		{Resources: []string{"logs", "events", "namespaces", "stats", "networks"}, Verbs: []string{"get", "list"}},
+	},
+	RoleReadOnly: {
+		{Resources: []string{"tasks", "logs", "events", "namespaces", "stats", "secrets", "configs", "volumes", "networks", "services"}, Verbs: []string{"get", "list"}},
+	},
+	RoleService: {
+		{Resources: []string{Wildcard}, Verbs: []string{Wildcard}},
+	},
+	RoleWorker: {
+		{Resources: []string{"workers"}, Verbs: []string{"create"}},
+	},
+}
+
+func (r Rule) allows(resource Resource, verb Verb) bool {
+	return matches(r.Resources, string(resource)) && matches(r.Verbs, string(verb))
+}
+
+func matches(values []string, v string) bool {
+	for _, s := range values {
+		if s == Wildcard || s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Principal is the identity a request was authenticated as.
+type Principal struct {
+	Name string
+	Role Role
+	// Namespaces the principal is limited to; empty means all of them
+	Namespaces []string
+}
+
+// TokenConfig binds a bearer token to a principal.
+type TokenConfig struct {
+	Principal
+	Token string
+}
+
+// Config is the on-disk authentication configuration. Roles adds to, or
+// overrides, DefaultRoles.
+type Config struct {
+	Tokens []TokenConfig
+	Roles  map[Role][]Rule
+}
+
+// Authorizer authenticates bearer tokens and checks what their principals
+// may do. A nil *Authorizer lets every request through, which keeps local
+// development setups working without any configuration.
+type Authorizer struct {
+	tokens map[[sha256.Size]byte]Principal
+	roles  map[Role][]Rule
+	// certRoles maps the organization of a verified client certificate to
+	// the role its holder gets
+	certRoles map[string]Role
+}
+
+func NewAuthorizer(cfg Config) (*Authorizer, error) {
+	a := &Authorizer{
+		tokens:    make(map[[sha256.Size]byte]Principal),
+		roles:     make(map[Role][]Rule),
+		certRoles: make(map[string]Role),
+	}
+
+	for role, rules := range DefaultRoles {
+		a.roles[role] = rules
+	}
+	for role, rules := range cfg.Roles {
+		a.roles[role] = rules
+	}
+
+	for _, t := range cfg.Tokens {
+		if err := a.AddToken(t.Token, t.Principal); err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+// LoadConfig reads a JSON Config from path and builds an Authorizer from it.
+func LoadConfig(path string) (*Authorizer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading auth config: %w", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("error parsing auth config: %w", err)
+	}
+
+	return NewAuthorizer(cfg)
+}
+
+// AddToken registers a token for p. Only a hash of the token is kept.
+func (a *Authorizer) AddToken(token string, p Principal) error {
+	if token == "" {
+		return fmt.Errorf("empty token for principal %q", p.Name)
+	}
+	if _, ok := a.roles[p.Role]; !ok {
+		return fmt.Errorf("unknown role %q for principal %q", p.Role, p.Name)
+	}
+
+	a.tokens[sha256.Sum256([]byte(token))] = p
+	return nil
+}
+
+// TrustCertificates authenticates requests that carry no bearer token but
+// present a client certificate, verified by the TLS layer, issued to the
+// given organization. The principal is named after the certificate's common
+// name.
+func (a *Authorizer) TrustCertificates(organization string, role Role) error {
+	if _, ok := a.roles[role]; !ok {
+		return fmt.Errorf("unknown role %q for organization %q", role, organization)
+	}
+
+	a.certRoles[organization] = role
+	return nil
+}
+
+// principal finds who the request was made by, from its bearer token or
+// else its client certificate.
+func (a *Authorizer) principal(r *http.Request) (Principal, bool) {
+	if token := httputil.BearerToken(r); token != "" {
+		p, ok := a.tokens[sha256.Sum256([]byte(token))]
+		return p, ok
+	}
+
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return Principal{}, false
+	}
+
+	leaf := r.TLS.VerifiedChains[0][0]
+	for _, org := range leaf.Subject.Organization {
+		if role, ok := a.certRoles[org]; ok {
+			return Principal{Name: leaf.Subject.CommonName, Role: role}, true
+		}
+	}
+
+	return Principal{}, false
+}
+
+// Can reports whether the principal's role grants verb on resource.
+func (a *Authorizer) Can(p Principal, resource Resource, verb Verb) bool {
+	for _, r := range a.roles[p.Role] {
+		if r.allows(resource, verb) {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey struct{}
+
+// PrincipalFrom returns the principal stored in ctx by Authenticate.
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(contextKey{}).(Principal)
+	return p, ok
+}
+
+// PrincipalName returns the name of the request's principal, or an empty
+// string when requests are not authenticated.
+func PrincipalName(r *http.Request) string {
+	p, _ := PrincipalFrom(r.Context())
+	return p.Name
+}
+
+// Authenticate rejects requests without a known bearer token or trusted
+// client certificate and stores the principal in the request context.
+func (a *Authorizer) Authenticate(next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := a.principal(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			httputil.WriteError(w, http.StatusUnauthorized, "Missing or invalid credentials")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), contextKey{}, p)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Require only lets through requests whose principal may perform verb on
+// resource. It must run after Authenticate.
+func (a *Authorizer) Require(resource Resource, verb Verb) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if a == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, ok := PrincipalFrom(r.Context())
+			if !ok || !a.Can(p, resource, verb) {
+				log.WithFields(map[string]interface{}{
+					"principal": p.Name,
+					"role":      p.Role,
+					"resource":  resource,
+					"verb":      verb,
+				}).Warn("Permission denied")
+				httputil.WriteError(w, http.StatusForbidden, fmt.Sprintf("Not allowed to %s %s", verb, resource))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Unrestricted reports whether the request's principal may act in every
+// namespace, which is always the case when authentication is off.
+func Unrestricted(ctx context.Context) bool {
+	p, ok := PrincipalFrom(ctx)
+	return !ok || len(p.Namespaces) == 0 || matches(p.Namespaces, Wildcard)
+}
+
+// AllowedNamespace reports whether the request's principal may act in the
+// given namespace.
+func AllowedNamespace(ctx context.Context, namespace string) bool {
+	if Unrestricted(ctx) {
+		return true
+	}
+
+	p, _ := PrincipalFrom(ctx)
+	return matches(p.Namespaces, namespace)
+}
+
+// VisibleNamespaces narrows the requested namespaces down to those the
+// request's principal may see. An empty request stands for every namespace,
+// and a nil result means no restriction. ok is false when none of the
+// requested namespaces are visible.
+func VisibleNamespaces(ctx context.Context, requested []string) (namespaces []string, ok bool) {
+	if Unrestricted(ctx) {
+		return requested, true
+	}
+
+	p, _ := PrincipalFrom(ctx)
+
+	if len(requested) == 0 {
+		return p.Namespaces, true
+	}
+
+	for _, ns := range requested {
+		if matches(p.Namespaces, ns) {
+			namespaces = append(namespaces, ns)
+		}
+	}
+
+	return namespaces, len(namespaces) > 0
+}
@@ -0,0 +1,48 @@
+// Command ingress runs the ingress proxy apart from the manager, taking the
+// services' routes from the manager's API.
+package main
+
+import (
+	"Mine-Cube/ingress"
+	"Mine-Cube/logger"
+	"Mine-Cube/pki"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+func main() {
+	logger.Initialize()
+
+	ih := os.Getenv("INGRESS_HOST")
+	ip, err := strconv.Atoi(os.Getenv("INGRESS_PORT"))
+	if err != nil {
+		logger.Fatalf("INGRESS_PORT must be set to a port number: %v", err)
+	}
+
+	managerAddress := os.Getenv("MANAGER_ADDRESS")
+	if managerAddress == "" {
+		managerAddress = fmt.Sprintf("%s:%s", os.Getenv("MANAGER_HOST"), os.Getenv("MANAGER_PORT"))
+	}
+
+	// CA_CERT means the manager's API is served over TLS
+	scheme := "http"
+	var roots *x509.CertPool
+	if caCert := os.Getenv("CA_CERT"); caCert != "" {
+		roots, err = pki.LoadPool(caCert)
+		if err != nil {
+			logger.Fatalf("Error loading CA certificate: %v", err)
+		}
+		scheme = "https"
+	}
+
+	// INGRESS_TOKEN authenticates the proxy to the manager; it needs to
+	// list services in the namespaces it serves
+	discovery := ingress.NewAPIDiscovery(fmt.Sprintf("%s://%s", scheme, managerAddress), os.Getenv("INGRESS_TOKEN"), roots)
+
+	proxy := ingress.Proxy{Address: ih, Port: ip, Discovery: discovery}
+	if err := proxy.Start(); err != nil {
+		logger.Fatalf("Ingress proxy stopped: %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package ingress
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var DISCOVERY_TIMEOUT = 10 * time.Second
+
+// APIDiscovery takes the routes from the manager's API, for a proxy that
+// runs apart from the manager. When the manager cannot be reached it keeps
+// serving the last routes it got.
+type APIDiscovery struct {
+	// ManagerURL is the base URL of the manager's API, such as
+	// "https://manager:5556"
+	ManagerURL string
+	// Token is the bearer token sent to the manager, if any
+	Token string
+
+	client *http.Client
+	last   Table
+}
+
+// NewAPIDiscovery returns a Discovery backed by the manager at managerURL.
+// roots verifies the manager's certificate when it is served over TLS; nil
+// uses the system's roots.
+func NewAPIDiscovery(managerURL string, token string, roots *x509.CertPool) *APIDiscovery {
+	return &APIDiscovery{
+		ManagerURL: managerURL,
+		Token:      token,
+		client: &http.Client{
+			Timeout:   DISCOVERY_TIMEOUT,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}},
+		},
+	}
+}
+
+func (d *APIDiscovery) IngressTable() Table {
+	table, err := d.fetch()
+	if err != nil {
+		log.WithField("manager", d.ManagerURL).Warnf("Error fetching ingress routes, keeping the last ones: %v", err)
+		return d.last
+	}
+
+	d.last = table
+	return table
+}
+
+func (d *APIDiscovery) fetch() (Table, error) {
+	req, err := http.NewRequest(http.MethodGet, d.ManagerURL+"/ingress", nil)
+	if err != nil {
+		return Table{}, err
+	}
+	if d.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+d.Token)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return Table{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Table{}, fmt.Errorf("manager responded with status %d", resp.StatusCode)
+	}
+
+	table := Table{}
+	if err := json.NewDecoder(resp.Body).Decode(&table); err != nil {
+		return Table{}, fmt.Errorf("error decoding ingress routes: %w", err)
+	}
+
+	return table, nil
+}
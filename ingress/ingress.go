@@ -0,0 +1,53 @@
+package ingress
+
+import (
+	"Mine-Cube/logger"
+)
+
+var log = logger.GetLogger("ingress")
+
+// Balancer picks which backend of a route receives the next request or
+// connection.
+type Balancer string
+
+const (
+	RoundRobin       Balancer = "round_robin"
+	LeastConnections Balancer = "least_connections"
+)
+
+// Route sends HTTP requests whose Host and path match to a service's
+// backends. An empty Host matches any host.
+type Route struct {
+	// Name identifies the service and port the route leads to; routes
+	// with the same name share their backends' state
+	Name       string
+	Host       string
+	PathPrefix string
+	Balancer   Balancer
+	// Retries is how many other backends a request is tried on when it
+	// cannot be delivered
+	Retries int
+	// Backends are the "host:port" addresses of the serving tasks
+	Backends []string
+}
+
+// TCPRoute forwards connections accepted on ListenPort to a service's
+// backends.
+type TCPRoute struct {
+	Name       string
+	ListenPort int
+	Balancer   Balancer
+	Retries    int
+	Backends   []string
+}
+
+type Table struct {
+	HTTP []Route
+	TCP  []TCPRoute
+}
+
+// Discovery provides the current routes and their backends, such as the
+// manager does from its service and task databases.
+type Discovery interface {
+	IngressTable() Table
+}
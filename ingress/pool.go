@@ -0,0 +1,138 @@
+package ingress
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// OUTLIER_MAX_FAILURES consecutive failures eject a backend from its pool
+// for OUTLIER_EJECTION_TIME.
+var OUTLIER_MAX_FAILURES = 5
+var OUTLIER_EJECTION_TIME = 30 * time.Second
+
+var ErrNoBackends = errors.New("no backends available")
+
+type backend struct {
+	address      string
+	active       int
+	failures     int
+	ejectedUntil time.Time
+}
+
+// pool tracks the backends of one route: their open requests or
+// connections, and the ones ejected after failing.
+type pool struct {
+	mu       sync.Mutex
+	name     string
+	balancer Balancer
+	backends []*backend
+	next     int
+}
+
+func newPool(name string) *pool {
+	return &pool{name: name}
+}
+
+// update replaces the pool's backends, keeping the state of the ones that
+// remain.
+func (p *pool) update(balancer Balancer, addresses []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	existing := make(map[string]*backend, len(p.backends))
+	for _, b := range p.backends {
+		existing[b.address] = b
+	}
+
+	backends := make([]*backend, 0, len(addresses))
+	for _, address := range addresses {
+		b, ok := existing[address]
+		if !ok {
+			b = &backend{address: address}
+		}
+		backends = append(backends, b)
+	}
+
+	p.balancer = balancer
+	p.backends = backends
+}
+
+// acquire picks a backend that is not in tried and counts a request or
+// connection to it, which release ends. Ejected backends are only picked
+// when every other one is ejected too, so that a service whose backends
+// all fail is still tried.
+func (p *pool) acquire(tried map[string]bool) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	var candidates, ejected []*backend
+	for _, b := range p.backends {
+		if tried[b.address] {
+			continue
+		}
+		if now.Before(b.ejectedUntil) {
+			ejected = append(ejected, b)
+		} else {
+			candidates = append(candidates, b)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = ejected
+	}
+	if len(candidates) == 0 {
+		return "", ErrNoBackends
+	}
+
+	var picked *backend
+	switch p.balancer {
+	case LeastConnections:
+		for _, b := range candidates {
+			if picked == nil || b.active < picked.active {
+				picked = b
+			}
+		}
+	default:
+		picked = candidates[p.next%len(candidates)]
+		p.next++
+	}
+
+	picked.active++
+	return picked.address, nil
+}
+
+// release ends a request or connection to a backend and records whether it
+// succeeded.
+func (p *pool) release(address string, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, b := range p.backends {
+		if b.address != address {
+			continue
+		}
+
+		if b.active > 0 {
+			b.active--
+		}
+
+		if ok {
+			b.failures = 0
+			return
+		}
+
+		b.failures++
+		if b.failures >= OUTLIER_MAX_FAILURES {
+			b.failures = 0
+			b.ejectedUntil = time.Now().Add(OUTLIER_EJECTION_TIME)
+
+			log.WithFields(map[string]interface{}{
+				"route":    p.name,
+				"backend":  address,
+				"duration": OUTLIER_EJECTION_TIME,
+			}).Warn("Backend ejected after consecutive failures")
+		}
+		return
+	}
+}
@@ -0,0 +1,129 @@
+package ingress
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPoolRoundRobin(t *testing.T) {
+	p := newPool("test")
+	p.update(RoundRobin, []string{"a:1", "b:1", "c:1"})
+
+	var got []string
+	for i := 0; i < 6; i++ {
+		address, err := p.acquire(nil)
+		if err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		p.release(address, true)
+		got = append(got, address)
+	}
+
+	want := []string{"a:1", "b:1", "c:1", "a:1", "b:1", "c:1"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("picked %v, want %v", got, want)
+		}
+	}
+}
+
+func TestPoolLeastConnections(t *testing.T) {
+	p := newPool("test")
+	p.update(LeastConnections, []string{"a:1", "b:1"})
+
+	first, _ := p.acquire(nil)
+	second, _ := p.acquire(nil)
+	if first == second {
+		t.Fatalf("both requests went to %s while the other backend was idle", first)
+	}
+
+	p.release(second, true)
+	third, _ := p.acquire(nil)
+	if third != second {
+		t.Errorf("picked %s, want the idle backend %s", third, second)
+	}
+}
+
+func TestPoolSkipsTriedBackends(t *testing.T) {
+	p := newPool("test")
+	p.update(RoundRobin, []string{"a:1", "b:1"})
+
+	address, err := p.acquire(map[string]bool{"a:1": true})
+	if err != nil || address != "b:1" {
+		t.Fatalf("acquire = %q, %v; want b:1", address, err)
+	}
+
+	if _, err := p.acquire(map[string]bool{"a:1": true, "b:1": true}); err != ErrNoBackends {
+		t.Errorf("acquire with every backend tried = %v, want ErrNoBackends", err)
+	}
+}
+
+func TestPoolEjectsFailingBackend(t *testing.T) {
+	p := newPool("test")
+	p.update(RoundRobin, []string{"a:1", "b:1"})
+
+	for i := 0; i < OUTLIER_MAX_FAILURES; i++ {
+		p.release("a:1", false)
+	}
+
+	for i := 0; i < 4; i++ {
+		address, _ := p.acquire(nil)
+		p.release(address, true)
+		if address != "b:1" {
+			t.Fatalf("picked ejected backend %s", address)
+		}
+	}
+
+	// with every backend ejected, the ejected ones are still tried
+	for i := 0; i < OUTLIER_MAX_FAILURES; i++ {
+		p.release("b:1", false)
+	}
+	if _, err := p.acquire(nil); err != nil {
+		t.Errorf("acquire with every backend ejected: %v", err)
+	}
+}
+
+func TestPoolEjectionExpires(t *testing.T) {
+	p := newPool("test")
+	p.update(RoundRobin, []string{"a:1"})
+	p.backends[0].ejectedUntil = time.Now().Add(-time.Second)
+	p.update(RoundRobin, []string{"a:1", "b:1"})
+
+	address, _ := p.acquire(nil)
+	if address != "a:1" {
+		t.Errorf("picked %s, want a:1 back after its ejection ended", address)
+	}
+}
+
+func TestPoolSuccessResetsFailures(t *testing.T) {
+	p := newPool("test")
+	p.update(RoundRobin, []string{"a:1"})
+
+	for i := 0; i < OUTLIER_MAX_FAILURES-1; i++ {
+		p.release("a:1", false)
+	}
+	p.release("a:1", true)
+	p.release("a:1", false)
+
+	if !p.backends[0].ejectedUntil.IsZero() {
+		t.Error("backend ejected although a success broke its run of failures")
+	}
+}
+
+func TestPoolUpdateKeepsBackendState(t *testing.T) {
+	p := newPool("test")
+	p.update(LeastConnections, []string{"a:1", "b:1"})
+
+	address, _ := p.acquire(nil)
+	p.update(LeastConnections, []string{"b:1", "a:1", "c:1"})
+
+	for _, b := range p.backends {
+		want := 0
+		if b.address == address {
+			want = 1
+		}
+		if b.active != want {
+			t.Errorf("backend %s has %d active requests after update, want %d", b.address, b.active, want)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package ingress
+
+import (
+	httputil "Mine-Cube/utils/http"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	nethttputil "net/http/httputil"
+	"strings"
+	"sync"
+	"time"
+)
+
+var REFRESH_ROUTES_INTERVAL = 5 * time.Second
+var TCP_DIAL_TIMEOUT = 5 * time.Second
+
+// Proxy is the ingress load balancer. It serves the HTTP routes on its own
+// address and port, and listens on the port of every TCP route, refreshing
+// both from its Discovery.
+type Proxy struct {
+	Address   string
+	Port      int
+	Discovery Discovery
+
+	mu        sync.RWMutex
+	routes    []Route
+	tcpRoutes map[int]TCPRoute
+	pools     map[string]*pool
+	listeners map[int]net.Listener
+}
+
+func (p *Proxy) Start() error {
+	p.refresh()
+	go p.refreshRoutes()
+
+	addr := fmt.Sprintf("%s:%d", p.Address, p.Port)
+
+	log.WithField("address", addr).Info("Starting ingress proxy")
+
+	return http.ListenAndServe(addr, p)
+}
+
+func (p *Proxy) refreshRoutes() {
+	for {
+		time.Sleep(REFRESH_ROUTES_INTERVAL)
+		p.refresh()
+	}
+}
+
+// refresh takes the current routes and backends from the Discovery, opening
+// and closing TCP listeners to match.
+func (p *Proxy) refresh() {
+	table := p.Discovery.IngressTable()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pools == nil {
+		p.pools = make(map[string]*pool)
+		p.listeners = make(map[int]net.Listener)
+	}
+
+	pools := make(map[string]*pool, len(table.HTTP)+len(table.TCP))
+	usePool := func(name string, balancer Balancer, backends []string) {
+		pl, ok := p.pools[name]
+		if !ok {
+			pl = newPool(name)
+		}
+		pl.update(balancer, backends)
+		pools[name] = pl
+	}
+
+	for _, r := range table.HTTP {
+		usePool(r.Name, r.Balancer, r.Backends)
+	}
+
+	tcpRoutes := make(map[int]TCPRoute, len(table.TCP))
+	for _, r := range table.TCP {
+		usePool(r.Name, r.Balancer, r.Backends)
+		tcpRoutes[r.ListenPort] = r
+
+		if _, ok := p.listeners[r.ListenPort]; ok {
+			continue
+		}
+
+		addr := fmt.Sprintf("%s:%d", p.Address, r.ListenPort)
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.WithFields(map[string]interface{}{
+				"route":   r.Name,
+				"address": addr,
+			}).Errorf("Error listening for TCP route: %v", err)
+			continue
+		}
+		p.listeners[r.ListenPort] = ln
+		go p.serveTCP(r.ListenPort, ln)
+
+		log.WithFields(map[string]interface{}{
+			"route":   r.Name,
+			"address": addr,
+		}).Info("Listening for TCP route")
+	}
+
+	for port, ln := range p.listeners {
+		if _, ok := tcpRoutes[port]; !ok {
+			ln.Close()
+			delete(p.listeners, port)
+		}
+	}
+
+	p.routes = table.HTTP
+	p.tcpRoutes = tcpRoutes
+	p.pools = pools
+}
+
+// match finds the route for a request: the one with the longest matching
+// path prefix, preferring routes for the request's host over routes for
+// any host.
+func (p *Proxy) match(r *http.Request) (Route, *pool, bool) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	best := -1
+	bestScore := -1
+	for i, route := range p.routes {
+		if route.Host != "" && !strings.EqualFold(route.Host, host) {
+			continue
+		}
+		if !strings.HasPrefix(r.URL.Path, route.PathPrefix) {
+			continue
+		}
+
+		score := 2 * len(route.PathPrefix)
+		if route.Host != "" {
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+
+	if best < 0 {
+		return Route{}, nil, false
+	}
+
+	route := p.routes[best]
+	return route, p.pools[route.Name], true
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route, pl, ok := p.match(r)
+	if !ok {
+		httputil.WriteError(w, http.StatusNotFound, fmt.Sprintf("No route for %s%s", r.Host, r.URL.Path))
+		return
+	}
+
+	proxy := &nethttputil.ReverseProxy{
+		Rewrite: func(pr *nethttputil.ProxyRequest) {
+			// the backend is picked by the transport
+			pr.Out.URL.Scheme = "http"
+			pr.Out.URL.Host = route.Name
+			pr.SetXForwarded()
+		},
+		Transport:     &balancingTransport{pool: pl, retries: route.Retries},
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.WithField("route", route.Name).Warnf("Error proxying request: %v", err)
+			if errors.Is(err, ErrNoBackends) {
+				httputil.WriteError(w, http.StatusServiceUnavailable, fmt.Sprintf("No backends available for %s", route.Name))
+				return
+			}
+			httputil.WriteError(w, http.StatusBadGateway, fmt.Sprintf("Error connecting to %s: %v", route.Name, err))
+		},
+	}
+
+	proxy.ServeHTTP(w, r)
+}
+
+// balancingTransport sends each request to a backend picked from the pool,
+// trying others when the request cannot be delivered. Requests with a body
+// are not retried, as it has been consumed.
+type balancingTransport struct {
+	pool    *pool
+	retries int
+}
+
+func (t *balancingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tried := make(map[string]bool)
+	var lastErr error
+
+	for attempt := 0; attempt <= t.retries; attempt++ {
+		if attempt > 0 && req.Body != nil {
+			break
+		}
+
+		address, err := t.pool.acquire(tried)
+		if err != nil {
+			if lastErr != nil {
+				return nil, lastErr
+			}
+			return nil, err
+		}
+		tried[address] = true
+
+		out := req.Clone(req.Context())
+		out.URL.Host = address
+
+		resp, err := http.DefaultTransport.RoundTrip(out)
+		if err != nil {
+			t.pool.release(address, false)
+			lastErr = err
+			continue
+		}
+
+		// gateway errors count towards ejection like connection errors
+		ok := resp.StatusCode != http.StatusBadGateway &&
+			resp.StatusCode != http.StatusServiceUnavailable &&
+			resp.StatusCode != http.StatusGatewayTimeout
+		resp.Body = &releasingBody{ReadCloser: resp.Body, release: func() { t.pool.release(address, ok) }}
+		return resp, nil
+	}
+
+	return nil, lastErr
+}
+
+// releasingBody ends a backend's request once the response is read.
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+func (p *Proxy) serveTCP(port int, ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.WithField("port", port).Warnf("Error accepting TCP connection: %v", err)
+			continue
+		}
+
+		go p.forward(port, conn)
+	}
+}
+
+// forward connects a client to a backend of the TCP route on its port and
+// copies data both ways until either side is done.
+func (p *Proxy) forward(port int, conn net.Conn) {
+	defer conn.Close()
+
+	p.mu.RLock()
+	route, ok := p.tcpRoutes[port]
+	pl := p.pools[route.Name]
+	p.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	tried := make(map[string]bool)
+	for attempt := 0; attempt <= route.Retries; attempt++ {
+		address, err := pl.acquire(tried)
+		if err != nil {
+			log.WithField("route", route.Name).Warnf("Dropping TCP connection: %v", err)
+			return
+		}
+		tried[address] = true
+
+		upstream, err := net.DialTimeout("tcp", address, TCP_DIAL_TIMEOUT)
+		if err != nil {
+			pl.release(address, false)
+			log.WithFields(map[string]interface{}{
+				"route":   route.Name,
+				"backend": address,
+			}).Warnf("Error connecting to backend: %v", err)
+			continue
+		}
+
+		pipe(conn, upstream)
+		upstream.Close()
+		pl.release(address, true)
+		return
+	}
+}
+
+func pipe(a net.Conn, b net.Conn) {
+	done := make(chan struct{}, 2)
+
+	copyHalf := func(dst net.Conn, src net.Conn) {
+		io.Copy(dst, src)
+		// let the other side see the end of the stream
+		if tc, ok := dst.(*net.TCPConn); ok {
+			tc.CloseWrite()
+		}
+		done <- struct{}{}
+	}
+
+	go copyHalf(a, b)
+	go copyHalf(b, a)
+
+	<-done
+	<-done
+}
@@ -1,13 +1,19 @@
 package main
 
 import (
+	"Mine-Cube/auth"
+	"Mine-Cube/ingress"
 	"Mine-Cube/logger"
 	"Mine-Cube/manager"
+	"Mine-Cube/pki"
 	"Mine-Cube/task"
 	"Mine-Cube/worker"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/golang-collections/collections/queue"
 	"github.com/google/uuid"
@@ -16,23 +22,122 @@ import (
 func main() {
 	logger.Initialize()
 
-	workerApi := setupWorker()
-	setupManager(workerApi)
+	// TLS_DIR turns on TLS for both APIs. The manager keeps its CA there,
+	// and workers read the CA certificate from it unless CA_CERT is set.
+	tlsDir := os.Getenv("TLS_DIR")
+
+	var ca *pki.CA
+	if tlsDir != "" {
+		var err error
+		ca, err = pki.LoadOrCreateCA(tlsDir)
+		if err != nil {
+			logger.Fatalf("Error loading certificate authority: %v", err)
+		}
+	}
+
+	workerApi := setupWorker(tlsDir)
+	setupManager(workerApi, ca)
+}
+
+// loadAuthorizer builds the API authorizer from the JSON file named by
+// AUTH_CONFIG. Without one, authentication is disabled unless required is
+// set, in which case the authorizer starts out with no tokens.
+func loadAuthorizer(required bool) *auth.Authorizer {
+	path := os.Getenv("AUTH_CONFIG")
+
+	var a *auth.Authorizer
+	var err error
+
+	switch {
+	case path != "":
+		a, err = auth.LoadConfig(path)
+	case required:
+		a, err = auth.NewAuthorizer(auth.Config{})
+	default:
+		logger.Warn("AUTH_CONFIG is not set, API authentication is disabled")
+		return nil
+	}
+
+	if err != nil {
+		logger.Fatalf("Error loading auth config: %v", err)
+	}
+
+	return a
 }
 
-func setupWorker() *worker.Api {
+func setupWorker(tlsDir string) *worker.Api {
 	wh := os.Getenv("WORKER_HOST")
 	wp, _ := strconv.Atoi(os.Getenv("WORKER_PORT"))
 
+	logDir := os.Getenv("WORKER_LOG_DIR")
+	if logDir == "" {
+		logDir = filepath.Join(os.TempDir(), "cube", "logs")
+	}
+	if retention, err := time.ParseDuration(os.Getenv("WORKER_LOG_RETENTION")); err == nil {
+		worker.LOG_RETENTION = retention
+	}
+
+	secretsDir := os.Getenv("WORKER_SECRETS_DIR")
+	if secretsDir == "" {
+		secretsDir = worker.DefaultSecretsDir()
+	}
+
+	configsDir := os.Getenv("WORKER_CONFIGS_DIR")
+	if configsDir == "" {
+		configsDir = filepath.Join(os.TempDir(), "cube", "configs")
+	}
+
 	w := worker.Worker{
-		Queue: *queue.New(),
-		Db:    make(map[uuid.UUID]*task.Task),
+		Queue:      *queue.New(),
+		Db:         make(map[uuid.UUID]*task.Task),
+		Logs:       worker.NewLogStore(logDir),
+		SecretsDir: secretsDir,
+		ConfigsDir: configsDir,
+	}
+
+	// WORKER_ALLOWED_BIND_PATHS lists the host paths tasks may bind mount
+	if paths := os.Getenv("WORKER_ALLOWED_BIND_PATHS"); paths != "" {
+		w.AllowedBindPaths = filepath.SplitList(paths)
+	}
+	serviceToken := os.Getenv("SERVICE_TOKEN")
+
+	wapi := worker.Api{Address: wh, Port: wp, Worker: &w, Auth: loadAuthorizer(serviceToken != "" || tlsDir != "")}
+
+	if serviceToken != "" {
+		if err := wapi.Auth.AddToken(serviceToken, auth.Principal{Name: "manager", Role: auth.RoleService}); err != nil {
+			logger.Fatalf("Error adding service token: %v", err)
+		}
+	}
+
+	if tlsDir != "" {
+		caCert := os.Getenv("CA_CERT")
+		if caCert == "" {
+			caCert = filepath.Join(tlsDir, pki.CACertFile)
+		}
+		roots, err := pki.LoadPool(caCert)
+		if err != nil {
+			logger.Fatalf("Error loading CA certificate: %v", err)
+		}
+
+		managerAddress := os.Getenv("MANAGER_ADDRESS")
+		if managerAddress == "" {
+			managerAddress = fmt.Sprintf("%s:%s", os.Getenv("MANAGER_HOST"), os.Getenv("MANAGER_PORT"))
+		}
+
+		reg := worker.NewRegistrar(managerAddress, roots, fmt.Sprintf("worker-%s-%d", wh, wp), fmt.Sprintf("%s:%d", wh, wp))
+		reg.JoinToken = os.Getenv("JOIN_TOKEN")
+
+		wapi.TLSConfig = reg.ServerConfig(roots)
+		wapi.Auth.TrustCertificates(pki.OrgManager, auth.RoleService)
+
+		go reg.Run()
 	}
-	wapi := worker.Api{Address: wh, Port: wp, Worker: &w}
 
 	go w.RunTasks()
 	go w.CollectStats()
 	go w.UpdateTasks()
+	go w.DoHealthChecks()
+	go w.PruneLogs()
 	go wapi.Start()
 
 	logger.WithFields(map[string]interface{}{
@@ -43,19 +148,98 @@ func setupWorker() *worker.Api {
 	return &wapi
 }
 
-func setupManager(workerApi *worker.Api) {
+func setupManager(workerApi *worker.Api, ca *pki.CA) {
 	mh := os.Getenv("MANAGER_HOST")
 	mp, _ := strconv.Atoi(os.Getenv("MANAGER_PORT"))
 
 	workers := []string{fmt.Sprintf("%s:%d", workerApi.Address, workerApi.Port)}
 
 	m := manager.NewManager(workers)
-	mapi := manager.Api{Address: mh, Port: mp, Manager: m}
+	m.ServiceToken = os.Getenv("SERVICE_TOKEN")
+
+	// SECRETS_MASTER_KEY enables secrets; SECRETS_FILE keeps them, encrypted,
+	// across restarts
+	if key := os.Getenv("SECRETS_MASTER_KEY"); key != "" {
+		masterKey, err := manager.ParseMasterKey(key)
+		if err != nil {
+			logger.Fatalf("Error reading secrets master key: %v", err)
+		}
+
+		m.Secrets, err = manager.NewSecretStore(masterKey, os.Getenv("SECRETS_FILE"))
+		if err != nil {
+			logger.Fatalf("Error loading secrets: %v", err)
+		}
+	} else {
+		logger.Warn("SECRETS_MASTER_KEY is not set, secrets are disabled")
+	}
+	// With TLS on, anyone who can call /workers/register gets a worker
+	// certificate, so registration must be authenticated
+	mapi := manager.Api{Address: mh, Port: mp, Manager: m, Auth: loadAuthorizer(ca != nil)}
+
+	joinToken := os.Getenv("JOIN_TOKEN")
+	if ca != nil && joinToken == "" && os.Getenv("AUTH_CONFIG") == "" {
+		logger.Fatal("TLS_DIR requires JOIN_TOKEN or AUTH_CONFIG so that worker registration is authenticated")
+	}
+
+	if joinToken != "" && mapi.Auth != nil {
+		if err := mapi.Auth.AddToken(joinToken, auth.Principal{Name: "join", Role: auth.RoleWorker}); err != nil {
+			logger.Fatalf("Error adding join token: %v", err)
+		}
+	}
+
+	if ca != nil {
+		hosts := []string{mh}
+		if mh == "" {
+			hosts = []string{"localhost", "127.0.0.1"}
+		}
+
+		// WORKER_CERT_HOSTS lists the hosts workers may register at,
+		// by default only that of the manager's own worker
+		if certHosts := os.Getenv("WORKER_CERT_HOSTS"); certHosts != "" {
+			m.WorkerCertHosts = strings.Split(certHosts, ",")
+		} else if workerApi.Address != "" {
+			m.WorkerCertHosts = []string{workerApi.Address}
+		} else {
+			m.WorkerCertHosts = []string{"localhost", "127.0.0.1"}
+		}
+
+		tlsConfig, err := m.UseTLS(ca, hosts)
+		if err != nil {
+			logger.Fatalf("Error issuing manager certificate: %v", err)
+		}
+		mapi.TLSConfig = tlsConfig
+
+		if mapi.Auth != nil {
+			mapi.Auth.TrustCertificates(pki.OrgWorker, auth.RoleWorker)
+		}
+
+		go m.RenewCertificates()
+	}
 
 	go m.ProcessTasks()
 	go m.UpdateTasks()
 	go m.DoHealthChecks()
 
+	// INGRESS_PORT runs the ingress proxy for the services' routes
+	if ingressPort, err := strconv.Atoi(os.Getenv("INGRESS_PORT")); err == nil {
+		proxy := ingress.Proxy{Address: mh, Port: ingressPort, Discovery: m}
+		go func() {
+			if err := proxy.Start(); err != nil {
+				logger.Errorf("Ingress proxy stopped: %v", err)
+			}
+		}()
+	}
+
+	// MANAGER_DNS_PORT enables service discovery over DNS
+	if dnsPort, err := strconv.Atoi(os.Getenv("MANAGER_DNS_PORT")); err == nil {
+		dnsServer := manager.DNSServer{Address: mh, Port: dnsPort, Manager: m}
+		go func() {
+			if err := dnsServer.Start(); err != nil {
+				logger.Errorf("DNS server stopped: %v", err)
+			}
+		}()
+	}
+
 	logger.WithFields(map[string]interface{}{
 		"address": mh,
 		"port":    mp,
@@ -1,6 +1,9 @@
 package manager
 
 import (
+	"Mine-Cube/auth"
+	httputil "Mine-Cube/utils/http"
+	"crypto/tls"
 	"fmt"
 	"net/http"
 
@@ -13,25 +16,123 @@ type Api struct {
 	Port    int
 	Manager *Manager
 	Router  *chi.Mux
+	// Auth checks every request's bearer token and permissions. All
+	// requests are allowed when it is nil.
+	Auth *auth.Authorizer
+	// TLSConfig serves the API over TLS when set
+	TLSConfig *tls.Config
+
+	idempotency *httputil.IdempotencyStore
 }
 
 func (a *Api) SetupRoutes() {
 	a.Router = chi.NewRouter()
 
 	a.Router.Use(middleware.Logger)
+	a.Router.Use(a.Auth.Authenticate)
+
+	can := a.Auth.Require
+
+	a.idempotency = httputil.NewIdempotencyStore()
+	idempotent := a.idempotency.Middleware(auth.PrincipalName)
 
 	a.Router.Route("/tasks", func(r chi.Router) {
-		r.Post("/", a.StartTaskHandler)
+		r.With(can(auth.ResourceTasks, auth.VerbCreate), idempotent).Post("/", a.StartTaskHandler)
 
-		r.Get("/", a.GetTasksHandler)
+		r.With(can(auth.ResourceTasks, auth.VerbList)).Get("/", a.GetTasksHandler)
+
+		r.With(can(auth.ResourceTasks, auth.VerbDelete)).Delete("/", a.StopTasksHandler)
 
 		r.Route("/{taskID}", func(r chi.Router) {
-			r.Delete("/", a.StopTaskHandler)
+			r.With(can(auth.ResourceTasks, auth.VerbGet)).Get("/", a.GetTaskHandler)
+			r.With(can(auth.ResourceTasks, auth.VerbUpdate)).Patch("/", a.UpdateTaskHandler)
+			r.With(can(auth.ResourceTasks, auth.VerbDelete)).Delete("/", a.StopTaskHandler)
+			r.With(can(auth.ResourceTasks, auth.VerbDelete)).Post("/kill", a.KillTaskHandler)
+			r.With(can(auth.ResourceTasks, auth.VerbUpdate)).Post("/pause", a.PauseTaskHandler)
+			r.With(can(auth.ResourceTasks, auth.VerbUpdate)).Post("/resume", a.ResumeTaskHandler)
+			r.With(can(auth.ResourceTasks, auth.VerbUpdate)).Post("/restart", a.RestartTaskHandler)
+			r.With(can(auth.ResourceLogs, auth.VerbGet)).Get("/logs", a.GetTaskLogsHandler)
+			r.With(can(auth.ResourceTasks, auth.VerbGet)).Get("/history", a.GetTaskHistoryHandler)
+			r.With(can(auth.ResourceTasks, auth.VerbGet)).Get("/revisions", a.GetTaskRevisionsHandler)
+
+			r.Group(func(r chi.Router) {
+				r.Use(can(auth.ResourceTasks, auth.VerbExec))
+
+				r.Post("/exec", a.ExecTaskHandler)
+				r.Get("/exec/ws", a.ExecWebSocketHandler)
+			})
 		})
 	})
+
+	a.Router.Route("/namespaces", func(r chi.Router) {
+		r.With(can(auth.ResourceNamespaces, auth.VerbCreate)).Post("/", a.CreateNamespaceHandler)
+		r.With(can(auth.ResourceNamespaces, auth.VerbList)).Get("/", a.GetNamespacesHandler)
+
+		r.Route("/{namespace}", func(r chi.Router) {
+			r.With(can(auth.ResourceNamespaces, auth.VerbGet)).Get("/", a.GetNamespaceHandler)
+			r.With(can(auth.ResourceNamespaces, auth.VerbDelete)).Delete("/", a.DeleteNamespaceHandler)
+			r.With(can(auth.ResourceNamespaces, auth.VerbUpdate)).Put("/quota", a.UpdateQuotaHandler)
+
+			r.Route("/secrets", func(r chi.Router) {
+				r.With(can(auth.ResourceSecrets, auth.VerbCreate)).Post("/", a.CreateSecretHandler)
+				r.With(can(auth.ResourceSecrets, auth.VerbList)).Get("/", a.GetSecretsHandler)
+				r.With(can(auth.ResourceSecrets, auth.VerbGet)).Get("/{secret}", a.GetSecretHandler)
+				r.With(can(auth.ResourceSecrets, auth.VerbUpdate)).Put("/{secret}", a.UpdateSecretHandler)
+				r.With(can(auth.ResourceSecrets, auth.VerbDelete)).Delete("/{secret}", a.DeleteSecretHandler)
+			})
+
+			r.Route("/configs", func(r chi.Router) {
+				r.With(can(auth.ResourceConfigs, auth.VerbCreate)).Post("/", a.CreateConfigHandler)
+				r.With(can(auth.ResourceConfigs, auth.VerbList)).Get("/", a.GetConfigsHandler)
+				r.With(can(auth.ResourceConfigs, auth.VerbGet)).Get("/{config}", a.GetConfigHandler)
+				r.With(can(auth.ResourceConfigs, auth.VerbGet)).Get("/{config}/versions/{version}", a.GetConfigVersionHandler)
+				r.With(can(auth.ResourceConfigs, auth.VerbUpdate)).Put("/{config}", a.UpdateConfigHandler)
+				r.With(can(auth.ResourceConfigs, auth.VerbDelete)).Delete("/{config}", a.DeleteConfigHandler)
+			})
+
+			r.Route("/volumes", func(r chi.Router) {
+				r.With(can(auth.ResourceVolumes, auth.VerbCreate)).Post("/", a.CreateVolumeHandler)
+				r.With(can(auth.ResourceVolumes, auth.VerbList)).Get("/", a.GetVolumesHandler)
+				r.With(can(auth.ResourceVolumes, auth.VerbGet)).Get("/{volume}", a.GetVolumeHandler)
+				r.With(can(auth.ResourceVolumes, auth.VerbDelete)).Delete("/{volume}", a.DeleteVolumeHandler)
+			})
+
+			r.Route("/services", func(r chi.Router) {
+				r.With(can(auth.ResourceServices, auth.VerbCreate)).Post("/", a.CreateServiceHandler)
+				r.With(can(auth.ResourceServices, auth.VerbList)).Get("/", a.GetServicesHandler)
+				r.With(can(auth.ResourceServices, auth.VerbGet)).Get("/{service}", a.GetServiceHandler)
+				r.With(can(auth.ResourceServices, auth.VerbUpdate)).Put("/{service}", a.UpdateServiceHandler)
+				r.With(can(auth.ResourceServices, auth.VerbDelete)).Delete("/{service}", a.DeleteServiceHandler)
+			})
+		})
+	})
+
+	a.Router.Route("/workers", func(r chi.Router) {
+		r.With(can(auth.ResourceWorkers, auth.VerbCreate)).Post("/register", a.RegisterWorkerHandler)
+	})
+
+	a.Router.Route("/catalog", func(r chi.Router) {
+		r.With(can(auth.ResourceServices, auth.VerbList)).Get("/services", a.GetCatalogServicesHandler)
+		r.With(can(auth.ResourceServices, auth.VerbGet)).Get("/services/{service}", a.GetCatalogServiceHandler)
+	})
+
+	a.Router.With(can(auth.ResourceServices, auth.VerbList)).Get("/ingress", a.GetIngressTableHandler)
+
+	a.Router.Route("/events", func(r chi.Router) {
+		r.With(can(auth.ResourceEvents, auth.VerbList)).Get("/", a.GetEventsHandler)
+	})
 }
 
 func (a *Api) Start() {
 	a.SetupRoutes()
-	http.ListenAndServe(fmt.Sprintf("%s:%d", a.Address, a.Port), a.Router)
+	addr := fmt.Sprintf("%s:%d", a.Address, a.Port)
+
+	if a.TLSConfig == nil {
+		http.ListenAndServe(addr, a.Router)
+		return
+	}
+
+	srv := &http.Server{Addr: addr, Handler: a.Router, TLSConfig: a.TLSConfig}
+	// the certificates come from TLSConfig, so that they can be rotated
+	srv.ListenAndServeTLS("", "")
 }
@@ -0,0 +1,162 @@
+package manager
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CATALOG_MAX_WAIT bounds how long a blocking catalog query waits for a
+// change.
+var CATALOG_MAX_WAIT = 5 * time.Minute
+var CATALOG_DEFAULT_WAIT = 60 * time.Second
+
+// CatalogService lists where the serving tasks of a service can be reached.
+type CatalogService struct {
+	Name      string
+	Namespace string
+	// Tasks counts the service's active tasks, serving or not
+	Tasks     int
+	Endpoints []Endpoint
+}
+
+// CatalogServices returns the services that have active tasks in the
+// namespaces for which include returns true.
+func (m *Manager) CatalogServices(include func(namespace string) bool) []CatalogService {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	type key struct{ namespace, name string }
+	counts := make(map[key]int)
+
+	for _, t := range m.TaskDb {
+		if t.Service == "" || !isActive(t) || !include(namespaceOf(t)) {
+			continue
+		}
+		counts[key{namespaceOf(t), t.Service}]++
+	}
+
+	services := make([]CatalogService, 0, len(counts))
+	for k, n := range counts {
+		services = append(services, CatalogService{
+			Name:      k.name,
+			Namespace: k.namespace,
+			Tasks:     n,
+			Endpoints: m.Endpoints(k.namespace, k.name),
+		})
+	}
+
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Namespace != services[j].Namespace {
+			return services[i].Namespace < services[j].Namespace
+		}
+		return services[i].Name < services[j].Name
+	})
+
+	return services
+}
+
+func (m *Manager) CatalogService(namespace string, name string) (CatalogService, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if !m.hasService(namespace, name) {
+		return CatalogService{}, ErrServiceNotFound
+	}
+
+	tasks := 0
+	for _, t := range m.TaskDb {
+		if t.Service == name && namespaceOf(t) == namespace && isActive(t) {
+			tasks++
+		}
+	}
+
+	return CatalogService{
+		Name:      name,
+		Namespace: namespace,
+		Tasks:     tasks,
+		Endpoints: m.Endpoints(namespace, name),
+	}, nil
+}
+
+// CatalogIndex is the position of the catalog in the event stream. Every
+// change to the catalog publishes an event, so the index grows whenever it
+// may have changed.
+func (m *Manager) CatalogIndex() uint64 {
+	return m.Events.LastID()
+}
+
+// WaitForCatalog blocks until an event after index that affects returns
+// true for has been published, wait has passed or ctx is done, and returns
+// the current index. It returns at once when events after index are no
+// longer known, or index is from before the manager restarted, as the
+// catalog may have changed in between.
+func (m *Manager) WaitForCatalog(ctx context.Context, index uint64, wait time.Duration, affects func(Event) bool) uint64 {
+	backlog, ch, complete := m.Events.Subscribe(index)
+	defer m.Events.Unsubscribe(ch)
+
+	if !complete {
+		return m.CatalogIndex()
+	}
+
+	for _, e := range backlog {
+		if affects(e) {
+			return m.CatalogIndex()
+		}
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		select {
+		case e, ok := <-ch:
+			// a dropped subscriber may have missed a change
+			if !ok || affects(e) {
+				return m.CatalogIndex()
+			}
+		case <-timer.C:
+			return m.CatalogIndex()
+		case <-ctx.Done():
+			return m.CatalogIndex()
+		}
+	}
+}
+
+// affectsService reports whether an event may change a service's
+// endpoints: events about its tasks, including ones that leave it, and
+// workers going up or down.
+func (m *Manager) affectsService(e Event, namespace string, name string) bool {
+	if e.Type == EventNodeStatusChanged {
+		return true
+	}
+	if e.TaskID == uuid.Nil || e.Namespace != namespace {
+		return false
+	}
+	// a task updated out of the service no longer has it as its service
+	if e.Data["PreviousService"] == name {
+		return true
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	t, ok := m.TaskDb[e.TaskID]
+	return ok && t.Service == name
+}
+
+// affectsCatalog reports whether an event may change the services listed
+// in the catalog.
+func (m *Manager) affectsCatalog(e Event) bool {
+	if e.Type == EventNodeStatusChanged {
+		return true
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	t, ok := m.TaskDb[e.TaskID]
+	return ok && t.Service != ""
+}
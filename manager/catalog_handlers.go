@@ -0,0 +1,100 @@
+package manager
+
+import (
+	"Mine-Cube/auth"
+	"Mine-Cube/task"
+	httputil "Mine-Cube/utils/http"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// blockingQuery handles the index and wait parameters of a catalog query.
+// With an index, it waits until the catalog may have changed since, for at
+// most wait. The current index is returned in the X-Cube-Index header, for
+// the client's next query.
+func (a *Api) blockingQuery(w http.ResponseWriter, r *http.Request, affects func(Event) bool) bool {
+	query := r.URL.Query()
+
+	if value := query.Get("index"); value != "" {
+		index, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("Invalid index: %q", value))
+			return false
+		}
+
+		wait := CATALOG_DEFAULT_WAIT
+		if value := query.Get("wait"); value != "" {
+			wait, err = time.ParseDuration(value)
+			if err != nil || wait <= 0 {
+				httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("Invalid wait: %q", value))
+				return false
+			}
+		}
+		if wait > CATALOG_MAX_WAIT {
+			wait = CATALOG_MAX_WAIT
+		}
+
+		a.Manager.WaitForCatalog(r.Context(), index, wait, affects)
+	}
+
+	w.Header().Set("X-Cube-Index", strconv.FormatUint(a.Manager.CatalogIndex(), 10))
+	return true
+}
+
+func (a *Api) GetCatalogServicesHandler(w http.ResponseWriter, r *http.Request) {
+	requested := r.URL.Query()["namespace"]
+	include := func(namespace string) bool {
+		if !auth.AllowedNamespace(r.Context(), namespace) {
+			return false
+		}
+		if len(requested) == 0 {
+			return true
+		}
+		for _, ns := range requested {
+			if ns == namespace {
+				return true
+			}
+		}
+		return false
+	}
+
+	if !a.blockingQuery(w, r, a.Manager.affectsCatalog) {
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, a.Manager.CatalogServices(include))
+}
+
+func (a *Api) GetCatalogServiceHandler(w http.ResponseWriter, r *http.Request) {
+	name, err := httputil.GetURLParam(r, "service")
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	namespace := r.URL.Query().Get("namespace")
+	if namespace == "" {
+		namespace = task.DefaultNamespace
+	}
+	if !auth.AllowedNamespace(r.Context(), namespace) {
+		httputil.WriteError(w, http.StatusForbidden, fmt.Sprintf("Not allowed in namespace: %s", namespace))
+		return
+	}
+
+	affects := func(e Event) bool {
+		return a.Manager.affectsService(e, namespace, name)
+	}
+	if !a.blockingQuery(w, r, affects) {
+		return
+	}
+
+	s, err := a.Manager.CatalogService(namespace, name)
+	if err != nil {
+		httputil.WriteError(w, http.StatusNotFound, fmt.Sprintf("No service found with name: %s/%s", namespace, name))
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, s)
+}
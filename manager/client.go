@@ -0,0 +1,59 @@
+package manager
+
+import (
+	httputil "Mine-Cube/utils/http"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// workerRequest sends a request to a worker's API, authenticated with the
+// manager's service credential and, when TLS is enabled, its client
+// certificate. A non-nil body is sent as JSON.
+func (m *Manager) workerRequest(method string, worker string, path string, body io.Reader) (*http.Response, error) {
+	req, err := m.newWorkerRequest(method, worker, path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.client.Do(req)
+}
+
+func (m *Manager) newWorkerRequest(method string, worker string, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, fmt.Sprintf("%s://%s%s", m.scheme, worker, path), body)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	m.authorizeWorkerRequest(req)
+
+	return req, nil
+}
+
+// postWork sends an encoded task event to a worker. The event's ID is the
+// request's idempotency key, so that an event sent again after a lost
+// response is only acted on once.
+func (m *Manager) postWork(worker string, eventID uuid.UUID, data []byte) (*http.Response, error) {
+	req, err := m.newWorkerRequest(http.MethodPost, worker, "/tasks", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(httputil.IdempotencyKeyHeader, eventID.String())
+
+	return m.client.Do(req)
+}
+
+// authorizeWorkerRequest replaces any credential on req with the manager's
+// service credential, so that callers' own tokens never reach workers.
+func (m *Manager) authorizeWorkerRequest(req *http.Request) {
+	req.Header.Del("Authorization")
+	if m.ServiceToken != "" {
+		req.Header.Set("Authorization", "Bearer "+m.ServiceToken)
+	}
+}
@@ -0,0 +1,354 @@
+package manager
+
+import (
+	"Mine-Cube/task"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var MAX_CONFIG_SIZE = 1024 * 1024
+
+// ROLLING_RESTART_TIMEOUT is how long a rolling restart waits for each task
+// to come back before it gives up on the rest.
+var ROLLING_RESTART_TIMEOUT = 5 * time.Minute
+var ROLLING_RESTART_CHECK_INTERVAL = 5 * time.Second
+
+var ErrConfigNotFound = errors.New("config not found")
+var ErrConfigExists = errors.New("config already exists")
+var ErrConfigInUse = errors.New("config is used by active tasks")
+var ErrInvalidConfigRef = errors.New("invalid config reference")
+
+type ConfigVersion struct {
+	Version   int
+	Data      string
+	CreatedAt time.Time
+}
+
+// Config is a named, versioned file that tasks of its namespace can mount.
+// Every update adds a version; older versions stay available to the tasks
+// pinned to them.
+type Config struct {
+	Name      string
+	Namespace string
+	CreatedAt time.Time
+	Versions  []ConfigVersion
+}
+
+// ConfigSummary describes a config without its contents.
+type ConfigSummary struct {
+	Name      string
+	Namespace string
+	Version   int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (c *Config) Latest() ConfigVersion {
+	return c.Versions[len(c.Versions)-1]
+}
+
+func (c *Config) Version(v int) (ConfigVersion, bool) {
+	if v < 1 || v > len(c.Versions) {
+		return ConfigVersion{}, false
+	}
+	return c.Versions[v-1], true
+}
+
+func (c *Config) Summary() ConfigSummary {
+	latest := c.Latest()
+	return ConfigSummary{
+		Name:      c.Name,
+		Namespace: c.Namespace,
+		Version:   latest.Version,
+		CreatedAt: c.CreatedAt,
+		UpdatedAt: latest.CreatedAt,
+	}
+}
+
+func configKey(namespace string, name string) string {
+	return namespace + "/" + name
+}
+
+func (m *Manager) CreateConfig(namespace string, name string, data string) (*Config, error) {
+	// configs are mounted from files named after them, like secrets
+	if err := ValidateSecretName(name); err != nil {
+		return nil, err
+	}
+	if len(data) > MAX_CONFIG_SIZE {
+		return nil, fmt.Errorf("config is larger than %d bytes", MAX_CONFIG_SIZE)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.ConfigDb[configKey(namespace, name)]; ok {
+		return nil, ErrConfigExists
+	}
+
+	now := time.Now().UTC()
+	c := &Config{
+		Name:      name,
+		Namespace: namespace,
+		CreatedAt: now,
+		Versions:  []ConfigVersion{{Version: 1, Data: data, CreatedAt: now}},
+	}
+	m.ConfigDb[configKey(namespace, name)] = c
+
+	log.WithFields(map[string]interface{}{
+		"namespace": namespace,
+		"config":    name,
+	}).Info("Config created")
+
+	created := *c
+	return &created, nil
+}
+
+// UpdateConfig adds a new version of a config. With rollingRestart set, the
+// active tasks using the config are moved to the new version and restarted
+// one at a time; their IDs are returned.
+func (m *Manager) UpdateConfig(namespace string, name string, data string, rollingRestart bool) (ConfigVersion, []uuid.UUID, error) {
+	if len(data) > MAX_CONFIG_SIZE {
+		return ConfigVersion{}, nil, fmt.Errorf("config is larger than %d bytes", MAX_CONFIG_SIZE)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.ConfigDb[configKey(namespace, name)]
+	if !ok {
+		return ConfigVersion{}, nil, ErrConfigNotFound
+	}
+
+	v := ConfigVersion{Version: c.Latest().Version + 1, Data: data, CreatedAt: time.Now().UTC()}
+	c.Versions = append(c.Versions, v)
+
+	log.WithFields(map[string]interface{}{
+		"namespace": namespace,
+		"config":    name,
+		"version":   v.Version,
+	}).Info("Config updated")
+
+	if !rollingRestart {
+		return v, nil, nil
+	}
+
+	users := m.configUsers(namespace, name)
+	ids := make([]uuid.UUID, 0, len(users))
+	for _, t := range users {
+		ids = append(ids, t.ID)
+	}
+
+	go m.rollingRestart(ids, name, v.Version)
+
+	return v, ids, nil
+}
+
+func (m *Manager) GetConfig(namespace string, name string) (*Config, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	c, ok := m.ConfigDb[configKey(namespace, name)]
+	if !ok {
+		return nil, ErrConfigNotFound
+	}
+
+	// versions are only ever appended, so the copy's stay as they are
+	config := *c
+	return &config, nil
+}
+
+func (m *Manager) GetConfigs(namespace string) []ConfigSummary {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	configs := []ConfigSummary{}
+	for _, c := range m.ConfigDb {
+		if c.Namespace == namespace {
+			configs = append(configs, c.Summary())
+		}
+	}
+
+	sort.Slice(configs, func(i, j int) bool {
+		return configs[i].Name < configs[j].Name
+	})
+
+	return configs
+}
+
+func (m *Manager) DeleteConfig(namespace string, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := configKey(namespace, name)
+	if _, ok := m.ConfigDb[key]; !ok {
+		return ErrConfigNotFound
+	}
+
+	if len(m.configUsers(namespace, name)) > 0 {
+		return ErrConfigInUse
+	}
+
+	delete(m.ConfigDb, key)
+
+	log.WithFields(map[string]interface{}{
+		"namespace": namespace,
+		"config":    name,
+	}).Info("Config deleted")
+
+	return nil
+}
+
+// configUsers returns the active tasks that mount a config.
+func (m *Manager) configUsers(namespace string, name string) []*task.Task {
+	var users []*task.Task
+
+	for _, t := range m.TaskDb {
+		if namespaceOf(t) != namespace || !isActive(t) {
+			continue
+		}
+		for _, ref := range t.Configs {
+			if ref.Name == name {
+				users = append(users, t)
+				break
+			}
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID.String() < users[j].ID.String()
+	})
+
+	return users
+}
+
+// resolveConfigRefs checks the configs a task mounts and pins references
+// without a version to the latest one.
+func (m *Manager) resolveConfigRefs(t *task.Task) error {
+	t.Configs = append([]task.ConfigRef(nil), t.Configs...)
+	seen := make(map[string]bool, len(t.Configs))
+
+	for i, ref := range t.Configs {
+		field := fmt.Sprintf("Configs[%d]", i)
+		if ref.File == "" || !filepath.IsAbs(ref.File) {
+			return specFieldError(field, fmt.Errorf("%w: config %q File must be an absolute path", ErrInvalidConfigRef, ref.Name))
+		}
+		if seen[ref.Name] {
+			return specFieldError(field, fmt.Errorf("%w: config %q is mounted more than once", ErrInvalidConfigRef, ref.Name))
+		}
+		seen[ref.Name] = true
+
+		c, ok := m.ConfigDb[configKey(namespaceOf(t), ref.Name)]
+		if !ok {
+			return specFieldError(field, fmt.Errorf("%w: %s/%s", ErrConfigNotFound, namespaceOf(t), ref.Name))
+		}
+
+		if ref.Version == 0 {
+			t.Configs[i].Version = c.Latest().Version
+		} else if _, ok := c.Version(ref.Version); !ok {
+			return specFieldError(field, fmt.Errorf("%w: config %s/%s has no version %d", ErrInvalidConfigRef, namespaceOf(t), ref.Name, ref.Version))
+		}
+	}
+
+	return nil
+}
+
+// configValues returns the contents of the config versions a task mounts.
+func (m *Manager) configValues(t *task.Task) (map[string][]byte, error) {
+	if len(t.Configs) == 0 {
+		return nil, nil
+	}
+
+	values := make(map[string][]byte, len(t.Configs))
+	for _, ref := range t.Configs {
+		c, ok := m.ConfigDb[configKey(namespaceOf(t), ref.Name)]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s/%s", ErrConfigNotFound, namespaceOf(t), ref.Name)
+		}
+
+		v, ok := c.Version(ref.Version)
+		if !ok {
+			return nil, fmt.Errorf("%w: config %s/%s has no version %d", ErrInvalidConfigRef, namespaceOf(t), ref.Name, ref.Version)
+		}
+		values[ref.Name] = []byte(v.Data)
+	}
+
+	return values, nil
+}
+
+// rollingRestart moves tasks to a new config version one at a time, waiting
+// for each to be running again, and healthy if it has a health check, before
+// restarting the next. It stops at the first task that does not come back.
+func (m *Manager) rollingRestart(ids []uuid.UUID, config string, version int) {
+	for _, id := range ids {
+		m.mu.Lock()
+		t, ok := m.TaskDb[id]
+		if !ok || !isActive(t) {
+			m.mu.Unlock()
+			continue
+		}
+
+		// copied, as earlier task events share the slice
+		configs := make([]task.ConfigRef, len(t.Configs))
+		for i, ref := range t.Configs {
+			if ref.Name == config {
+				ref.Version = version
+			}
+			configs[i] = ref
+		}
+		t.Configs = configs
+
+		// tasks that are not running yet start with the new version
+		if t.State != task.Running {
+			m.mu.Unlock()
+			continue
+		}
+
+		restartedAt := time.Now().UTC()
+		m.restartTask(t, task.SourceAPI, fmt.Sprintf("Rolling restart for config %s version %d", config, version))
+		m.mu.Unlock()
+
+		if !m.waitForRestart(id, restartedAt) {
+			log.WithFields(map[string]interface{}{
+				"task_id": id,
+				"config":  config,
+				"version": version,
+			}).Error("Task did not come back after restart, stopping rolling restart")
+			return
+		}
+	}
+
+	log.WithFields(map[string]interface{}{
+		"config":  config,
+		"version": version,
+		"tasks":   len(ids),
+	}).Info("Rolling restart completed")
+}
+
+func (m *Manager) waitForRestart(id uuid.UUID, since time.Time) bool {
+	deadline := time.Now().Add(ROLLING_RESTART_TIMEOUT)
+
+	for time.Now().Before(deadline) {
+		time.Sleep(ROLLING_RESTART_CHECK_INTERVAL)
+
+		m.mu.RLock()
+		t, ok := m.TaskDb[id]
+		failed := !ok || t.State == task.Failed
+		started := ok && t.State == task.Running && t.StartTime.After(since)
+		healthy := ok && (t.HealthCheck == "" || t.Health.Status == task.Healthy)
+		m.mu.RUnlock()
+
+		if failed {
+			return false
+		}
+		if started && healthy {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,191 @@
+package manager
+
+import (
+	httputil "Mine-Cube/utils/http"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+type configRequest struct {
+	Name string
+	Data string
+	// RollingRestart restarts the tasks using the config, one at a time,
+	// with the new version
+	RollingRestart bool
+}
+
+type configUpdateResponse struct {
+	ConfigVersion
+	// Tasks being restarted with the new version
+	Restarting []string
+}
+
+func writeConfigError(w http.ResponseWriter, err error, namespace string, name string) {
+	switch {
+	case errors.Is(err, ErrConfigNotFound):
+		httputil.WriteError(w, http.StatusNotFound, fmt.Sprintf("No config found with name: %s/%s", namespace, name))
+	case errors.Is(err, ErrConfigExists):
+		httputil.WriteError(w, http.StatusConflict, fmt.Sprintf("Config already exists: %s/%s", namespace, name))
+	case errors.Is(err, ErrConfigInUse):
+		httputil.WriteError(w, http.StatusConflict, fmt.Sprintf("Config %s/%s is used by active tasks", namespace, name))
+	default:
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+	}
+}
+
+func (a *Api) CreateConfigHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, ok := a.namespaceParam(w, r)
+	if !ok {
+		return
+	}
+
+	req, err := httputil.DecodeJSON[configRequest](r)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("%v", err))
+		return
+	}
+
+	c, err := a.Manager.CreateConfig(namespace, req.Name, req.Data)
+	if err != nil {
+		writeConfigError(w, err, namespace, req.Name)
+		return
+	}
+
+	handlerLog.WithFields(map[string]interface{}{
+		"namespace": namespace,
+		"config":    c.Name,
+	}).Info("Config created via API")
+	httputil.WriteJSON(w, http.StatusCreated, c)
+}
+
+func (a *Api) GetConfigsHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, ok := a.namespaceParam(w, r)
+	if !ok {
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, a.Manager.GetConfigs(namespace))
+}
+
+func (a *Api) GetConfigHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, ok := a.namespaceParam(w, r)
+	if !ok {
+		return
+	}
+
+	name, err := httputil.GetURLParam(r, "config")
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c, err := a.Manager.GetConfig(namespace, name)
+	if err != nil {
+		writeConfigError(w, err, namespace, name)
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, c)
+}
+
+func (a *Api) GetConfigVersionHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, ok := a.namespaceParam(w, r)
+	if !ok {
+		return
+	}
+
+	name, err := httputil.GetURLParam(r, "config")
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	param, err := httputil.GetURLParam(r, "version")
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	version, err := strconv.Atoi(param)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("Invalid version: %s", param))
+		return
+	}
+
+	c, err := a.Manager.GetConfig(namespace, name)
+	if err != nil {
+		writeConfigError(w, err, namespace, name)
+		return
+	}
+
+	v, ok := c.Version(version)
+	if !ok {
+		httputil.WriteError(w, http.StatusNotFound, fmt.Sprintf("Config %s/%s has no version %d", namespace, name, version))
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, v)
+}
+
+func (a *Api) UpdateConfigHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, ok := a.namespaceParam(w, r)
+	if !ok {
+		return
+	}
+
+	name, err := httputil.GetURLParam(r, "config")
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	req, err := httputil.DecodeJSON[configRequest](r)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("%v", err))
+		return
+	}
+
+	v, restarting, err := a.Manager.UpdateConfig(namespace, name, req.Data, req.RollingRestart)
+	if err != nil {
+		writeConfigError(w, err, namespace, name)
+		return
+	}
+
+	resp := configUpdateResponse{ConfigVersion: v, Restarting: []string{}}
+	for _, id := range restarting {
+		resp.Restarting = append(resp.Restarting, id.String())
+	}
+
+	handlerLog.WithFields(map[string]interface{}{
+		"namespace":  namespace,
+		"config":     name,
+		"version":    v.Version,
+		"restarting": len(restarting),
+	}).Info("Config updated via API")
+	httputil.WriteJSON(w, http.StatusOK, resp)
+}
+
+func (a *Api) DeleteConfigHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, ok := a.namespaceParam(w, r)
+	if !ok {
+		return
+	}
+
+	name, err := httputil.GetURLParam(r, "config")
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := a.Manager.DeleteConfig(namespace, name); err != nil {
+		writeConfigError(w, err, namespace, name)
+		return
+	}
+
+	handlerLog.WithFields(map[string]interface{}{
+		"namespace": namespace,
+		"config":    name,
+	}).Info("Config deleted via API")
+	httputil.WriteNoContent(w)
+}
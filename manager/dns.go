@@ -0,0 +1,168 @@
+package manager
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// DNS_TTL is kept short, as records change whenever tasks start, stop or
+// fail their health checks.
+var DNS_TTL uint32 = 5
+
+// DNS_DOMAIN is the zone the DNS server answers for. Services are named
+// <service>.<namespace>.svc.
+const DNS_DOMAIN = "svc."
+
+// DNSServer answers queries for the manager's services from the task
+// database, so its records always reflect the tasks that are serving:
+//
+//	<service>.<namespace>.svc                A    hosts of the serving tasks
+//	<service>.<namespace>.svc                SRV  every published port
+//	_<port>._<proto>.<service>.<namespace>.svc  SRV  one container port
+//	<task ID>.<service>.<namespace>.svc      A    host of one task
+type DNSServer struct {
+	Address string
+	Port    int
+	Manager *Manager
+}
+
+// Start serves DNS over UDP and TCP until either fails.
+func (s *DNSServer) Start() error {
+	mux := dns.NewServeMux()
+	mux.HandleFunc(DNS_DOMAIN, s.handleQuery)
+
+	addr := fmt.Sprintf("%s:%d", s.Address, s.Port)
+	errs := make(chan error, 2)
+
+	for _, network := range []string{"udp", "tcp"} {
+		srv := &dns.Server{Addr: addr, Net: network, Handler: mux}
+		go func() {
+			errs <- srv.ListenAndServe()
+		}()
+	}
+
+	log.WithFields(map[string]interface{}{
+		"address": addr,
+		"domain":  DNS_DOMAIN,
+	}).Info("Starting DNS server")
+
+	return <-errs
+}
+
+func (s *DNSServer) handleQuery(w dns.ResponseWriter, req *dns.Msg) {
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.Authoritative = true
+
+	// answers are read while the manager's loops may be changing tasks
+	s.Manager.mu.RLock()
+	for _, q := range req.Question {
+		if !s.answer(resp, q) {
+			resp.Rcode = dns.RcodeNameError
+		}
+	}
+	s.Manager.mu.RUnlock()
+
+	if err := w.WriteMsg(resp); err != nil {
+		log.Warnf("Error writing DNS response: %v", err)
+	}
+}
+
+// answer adds the records for one question, and reports whether the name
+// exists. Names of services without serving tasks exist but have no records.
+func (s *DNSServer) answer(resp *dns.Msg, q dns.Question) bool {
+	name := strings.ToLower(q.Name)
+	labels := dns.SplitDomainName(strings.TrimSuffix(name, DNS_DOMAIN))
+
+	var port, proto, taskID string
+	switch len(labels) {
+	case 2:
+	case 3:
+		taskID = labels[0]
+		labels = labels[1:]
+	case 4:
+		if !strings.HasPrefix(labels[0], "_") || !strings.HasPrefix(labels[1], "_") {
+			return false
+		}
+		port = strings.TrimPrefix(labels[0], "_")
+		proto = strings.TrimPrefix(labels[1], "_")
+		labels = labels[2:]
+	default:
+		return false
+	}
+
+	service, namespace := labels[0], labels[1]
+	if !s.Manager.hasService(namespace, service) {
+		return false
+	}
+
+	serviceName := dns.Fqdn(service + "." + namespace + "." + DNS_DOMAIN)
+	endpoints := s.Manager.Endpoints(namespace, service)
+
+	found := taskID == ""
+	hosts := make(map[string]bool)
+	for _, e := range endpoints {
+		if taskID != "" && e.TaskID.String() != taskID {
+			continue
+		}
+		if port != "" && (e.Port.Port() != port || e.Port.Proto() != proto) {
+			continue
+		}
+		found = true
+
+		switch q.Qtype {
+		case dns.TypeA:
+			if taskID != "" || port == "" {
+				hosts[e.Host] = true
+			}
+		case dns.TypeSRV:
+			if taskID != "" {
+				continue
+			}
+			target := dns.Fqdn(e.TaskID.String() + "." + serviceName)
+			resp.Answer = append(resp.Answer, &dns.SRV{
+				Hdr:      dns.RR_Header{Name: q.Name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: DNS_TTL},
+				Priority: 0,
+				Weight:   10,
+				Port:     uint16(e.HostPort),
+				Target:   target,
+			})
+			resp.Extra = append(resp.Extra, addressRecords(target, e.Host)...)
+		}
+	}
+
+	for host := range hosts {
+		resp.Answer = append(resp.Answer, addressRecords(q.Name, host)...)
+	}
+
+	return found
+}
+
+// addressRecords returns the A records of a worker host, resolving it when
+// it is not an address.
+func addressRecords(name string, host string) []dns.RR {
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		var err error
+		ips, err = net.LookupIP(host)
+		if err != nil {
+			log.WithField("host", host).Warnf("Error resolving worker host: %v", err)
+			return nil
+		}
+	}
+
+	var records []dns.RR
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			records = append(records, &dns.A{
+				Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: DNS_TTL},
+				A:   ip4,
+			})
+		}
+	}
+
+	return records
+}
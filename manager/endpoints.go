@@ -0,0 +1,118 @@
+package manager
+
+import (
+	"Mine-Cube/task"
+	"net"
+	"sort"
+	"strconv"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/google/uuid"
+)
+
+// Endpoint is where one published port of a service's task can be reached.
+type Endpoint struct {
+	TaskID uuid.UUID
+	// Worker is the address of the worker API the task runs on, and Host
+	// the host part of it, which the published ports are bound on
+	Worker string
+	Host   string
+	// Port is the container port, such as "80/tcp"
+	Port     nat.Port
+	HostPort int
+	Labels   map[string]string
+}
+
+// isServing reports whether a task should receive traffic: it is running on
+// a reachable worker and, if it has a health check, passing it.
+func (m *Manager) isServing(t *task.Task) bool {
+	if t.State != task.Running {
+		return false
+	}
+	if t.HealthCheck != "" && t.Health.Status != task.Healthy {
+		return false
+	}
+	return m.WorkerStatus[m.TaskWorkerMap[t.ID]] != "down"
+}
+
+func samePorts(a nat.PortMap, b nat.PortMap) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for port, bindings := range a {
+		other, ok := b[port]
+		if !ok || len(other) != len(bindings) {
+			return false
+		}
+		for i := range bindings {
+			if bindings[i] != other[i] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// hasService reports whether any task of the namespace belongs to the
+// service, serving or not.
+func (m *Manager) hasService(namespace string, service string) bool {
+	for _, t := range m.TaskDb {
+		if t.Service == service && namespaceOf(t) == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// Endpoints returns the published ports of the service's serving tasks,
+// ordered by task and port.
+func (m *Manager) Endpoints(namespace string, service string) []Endpoint {
+	endpoints := []Endpoint{}
+
+	for _, t := range m.TaskDb {
+		if t.Service != service || namespaceOf(t) != namespace || !m.isServing(t) {
+			continue
+		}
+
+		worker := m.TaskWorkerMap[t.ID]
+		host, _, err := net.SplitHostPort(worker)
+		if err != nil {
+			continue
+		}
+
+		for port, bindings := range t.HostPorts {
+			// Docker reports a binding per address family, usually on the
+			// same host port
+			seen := make(map[int]bool, len(bindings))
+			for _, b := range bindings {
+				hostPort, err := strconv.Atoi(b.HostPort)
+				if err != nil || seen[hostPort] {
+					continue
+				}
+				seen[hostPort] = true
+
+				endpoints = append(endpoints, Endpoint{
+					TaskID:   t.ID,
+					Worker:   worker,
+					Host:     host,
+					Port:     port,
+					HostPort: hostPort,
+					Labels:   t.Labels,
+				})
+			}
+		}
+	}
+
+	sort.Slice(endpoints, func(i, j int) bool {
+		a, b := endpoints[i], endpoints[j]
+		if a.TaskID != b.TaskID {
+			return a.TaskID.String() < b.TaskID.String()
+		}
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		return a.HostPort < b.HostPort
+	})
+
+	return endpoints
+}
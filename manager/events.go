@@ -0,0 +1,147 @@
+package manager
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var EVENT_HISTORY_SIZE = 1000
+var EVENT_SUBSCRIBER_BUFFER = 64
+
+type EventType string
+
+const (
+	EventTaskStateChanged    EventType = "task.state_changed"
+	EventTaskScheduled       EventType = "task.scheduled"
+	EventTaskHealthCheckFail EventType = "task.health_check_failed"
+	EventTaskRestarted       EventType = "task.restarted"
+	EventTaskHealthChanged   EventType = "task.health_changed"
+	EventTaskPortsChanged    EventType = "task.ports_changed"
+	EventTaskUpdated         EventType = "task.updated"
+	EventNodeStatusChanged   EventType = "node.status_changed"
+)
+
+// Event is a single entry in the manager's event stream. IDs increase
+// monotonically and double as the resume token for reconnecting clients.
+type Event struct {
+	ID        uint64
+	Type      EventType
+	Timestamp time.Time
+	// TaskID is the nil UUID for events that are not about a task
+	TaskID uuid.UUID
+	// Namespace and labels of the task at the time of the event
+	Namespace string
+	Labels    map[string]string
+	Worker    string
+	Data      map[string]interface{}
+}
+
+// EventBroker fans events out to subscribers and keeps the most recent
+// EVENT_HISTORY_SIZE of them, so that a client can resume from the last
+// event it saw.
+type EventBroker struct {
+	mu      sync.Mutex
+	lastID  uint64
+	history []Event
+	subs    map[chan Event]struct{}
+}
+
+func NewEventBroker() *EventBroker {
+	return &EventBroker{
+		subs: make(map[chan Event]struct{}),
+	}
+}
+
+// Publish stamps the event with the next ID and the current time and hands
+// it to every subscriber. Subscribers that have fallen too far behind are
+// dropped rather than allowed to block the manager; they can reconnect and
+// resume from their last event.
+func (b *EventBroker) Publish(e Event) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	e.ID = b.lastID
+	e.Timestamp = time.Now().UTC()
+
+	b.history = append(b.history, e)
+	if len(b.history) > EVENT_HISTORY_SIZE {
+		b.history = b.history[len(b.history)-EVENT_HISTORY_SIZE:]
+	}
+
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			log.Warn("Event subscriber is too slow, dropping it")
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+
+	return e
+}
+
+// Subscribe returns the retained events after the given ID together with a
+// channel for new ones. complete is false when some events after the given
+// ID are no longer retained, or when the ID is from before the manager
+// restarted and its IDs started over.
+func (b *EventBroker) Subscribe(after uint64) (backlog []Event, ch chan Event, complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	complete = true
+	if after > 0 && len(b.history) > 0 && b.history[0].ID > after+1 {
+		complete = false
+	}
+	if after > b.lastID {
+		complete = false
+	}
+
+	for _, e := range b.history {
+		if e.ID > after {
+			backlog = append(backlog, e)
+		}
+	}
+
+	ch = make(chan Event, EVENT_SUBSCRIBER_BUFFER)
+	b.subs[ch] = struct{}{}
+
+	return backlog, ch, complete
+}
+
+func (b *EventBroker) Unsubscribe(ch chan Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subs[ch]; ok {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// LastID returns the ID of the most recently published event.
+func (b *EventBroker) LastID() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.lastID
+}
+
+func (m *Manager) publish(eventType EventType, taskID uuid.UUID, worker string, data map[string]interface{}) {
+	e := Event{
+		Type:   eventType,
+		TaskID: taskID,
+		Worker: worker,
+		Data:   data,
+	}
+
+	if t, ok := m.TaskDb[taskID]; ok {
+		e.Namespace = namespaceOf(t)
+		e.Labels = t.Labels
+	}
+
+	m.Events.Publish(e)
+}
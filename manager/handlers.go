@@ -1,60 +1,507 @@
 package manager
 
 import (
+	"Mine-Cube/auth"
 	"Mine-Cube/logger"
 	"Mine-Cube/task"
 	httputil "Mine-Cube/utils/http"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 )
 
 var handlerLog = logger.GetLogger("manager.api")
 
+// getTask looks up the task named in the URL, writing an error response
+// when it does not exist or lies outside the caller's namespaces.
+func (a *Api) getTask(w http.ResponseWriter, r *http.Request) (*task.Task, bool) {
+	tID, err := httputil.GetUUIDParam(r, "taskID")
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("No taskID passed in request: %v", err))
+		return nil, false
+	}
+
+	t, ok := a.Manager.GetTask(tID)
+	if !ok {
+		httputil.WriteError(w, http.StatusNotFound, fmt.Sprintf("No task found with ID: %v", tID))
+		return nil, false
+	}
+
+	if !auth.AllowedNamespace(r.Context(), namespaceOf(t)) {
+		httputil.WriteError(w, http.StatusForbidden, fmt.Sprintf("Not allowed in namespace: %s", namespaceOf(t)))
+		return nil, false
+	}
+
+	return t, true
+}
+
+// StartTaskHandler submits a task from a TaskSpec. Invalid fields are
+// listed in the error response.
 func (a *Api) StartTaskHandler(w http.ResponseWriter, r *http.Request) {
-	te, err := httputil.DecodeJSON[task.TaskEvent](r)
+	spec, err := httputil.DecodeJSON[task.TaskSpec](r)
 	if err != nil {
 		httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("%v", err))
 		return
 	}
+	spec.Default()
+
+	if !auth.AllowedNamespace(r.Context(), spec.Namespace) {
+		httputil.WriteError(w, http.StatusForbidden, fmt.Sprintf("Not allowed in namespace: %s", spec.Namespace))
+		return
+	}
+
+	var validationErr *task.ValidationError
+	if err := a.Manager.ValidateSpec(&spec); errors.As(err, &validationErr) {
+		writeValidationError(w, validationErr)
+		return
+	}
+
+	t, err := spec.Task()
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	te := task.NewTaskEvent(t, task.SourceAPI, "Submitted via API")
+
+	err = a.Manager.SubmitTask(te)
+
+	var quotaErr *QuotaExceededError
+	var fieldErr *SpecFieldError
+	switch {
+	case errors.Is(err, ErrTaskExists):
+		httputil.WriteError(w, http.StatusConflict, fmt.Sprintf("Task already exists: %v", te.Task.ID))
+		return
+	case errors.Is(err, ErrNamespaceNotFound):
+		httputil.WriteError(w, http.StatusNotFound, fmt.Sprintf("No namespace found with name: %s", te.Task.Namespace))
+		return
+	case errors.As(err, &quotaErr):
+		httputil.WriteError(w, http.StatusForbidden, quotaErr.Error())
+		return
+	case errors.As(err, &fieldErr):
+		writeSpecFieldError(w, fieldErr)
+		return
+	case errors.Is(err, ErrSecretNotFound), errors.Is(err, ErrSecretsDisabled), errors.Is(err, ErrSecretsNeedTLS), errors.Is(err, ErrInvalidSecretRef),
+		errors.Is(err, ErrConfigNotFound), errors.Is(err, ErrInvalidConfigRef),
+		errors.Is(err, ErrVolumeNotFound), errors.Is(err, ErrInvalidVolume),
+		errors.Is(err, ErrInvalidNetwork):
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	case err != nil:
+		httputil.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 
-	a.Manager.AddTask(te)
 	handlerLog.WithField("task_id", te.Task.ID).Info("Task added via API")
-	httputil.WriteJSON(w, http.StatusCreated, te.Task)
+	submitted, _ := a.Manager.GetTask(te.Task.ID)
+	httputil.WriteJSON(w, http.StatusCreated, a.Manager.CopyTask(submitted))
 }
 
-func (a *Api) GetTasksHandler(w http.ResponseWriter, r *http.Request) {
-	httputil.WriteJSON(w, http.StatusOK, a.Manager.GetTasks())
+// UpdateTaskHandler changes the spec of a task. The body holds only the
+// fields to change, each of which replaces the field as a whole. The
+// response says which fields changed and how the change was applied.
+func (a *Api) UpdateTaskHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := a.getTask(w, r)
+	if !ok {
+		return
+	}
+
+	patch, err := httputil.DecodeJSON[map[string]json.RawMessage](r)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("%v", err))
+		return
+	}
+
+	var validationErr *task.ValidationError
+
+	current := a.Manager.CopyTask(t)
+	spec, err := PatchSpec(task.SpecOf(&current), patch)
+	if errors.As(err, &validationErr) {
+		writeValidationError(w, validationErr)
+		return
+	} else if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("%v", err))
+		return
+	}
+	spec.Default()
+
+	if err := a.Manager.ValidateSpec(&spec); errors.As(err, &validationErr) {
+		writeValidationError(w, validationErr)
+		return
+	}
+
+	update, err := a.Manager.UpdateTask(t, spec, "Updated via API")
+
+	var quotaErr *QuotaExceededError
+	var fieldErr *SpecFieldError
+	switch {
+	case errors.Is(err, ErrTaskNotRunning), errors.Is(err, ErrInvalidStateTransition):
+		httputil.WriteError(w, http.StatusConflict, fmt.Sprintf("Cannot update task %v: %v", t.ID, err))
+		return
+	case errors.As(err, &quotaErr):
+		httputil.WriteError(w, http.StatusForbidden, quotaErr.Error())
+		return
+	case errors.As(err, &fieldErr):
+		writeSpecFieldError(w, fieldErr)
+		return
+	case errors.Is(err, ErrSecretNotFound), errors.Is(err, ErrSecretsDisabled), errors.Is(err, ErrSecretsNeedTLS), errors.Is(err, ErrInvalidSecretRef),
+		errors.Is(err, ErrConfigNotFound), errors.Is(err, ErrInvalidConfigRef),
+		errors.Is(err, ErrVolumeNotFound), errors.Is(err, ErrInvalidVolume),
+		errors.Is(err, ErrInvalidNetwork):
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	case err != nil:
+		httputil.WriteError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	handlerLog.WithFields(map[string]interface{}{
+		"task_id":  t.ID,
+		"revision": update.Revision,
+		"strategy": update.Strategy,
+	}).Info("Task update requested via API")
+
+	httputil.WriteJSON(w, http.StatusOK, update)
 }
 
-func (a *Api) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
-	tID, err := httputil.GetUUIDParam(r, "taskID")
+func (a *Api) GetTaskRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := a.getTask(w, r)
+	if !ok {
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, a.Manager.GetSpecRevisions(t.ID))
+}
+
+func writeValidationError(w http.ResponseWriter, err *task.ValidationError) {
+	fields := make([]httputil.FieldError, 0, len(err.Fields))
+	for _, f := range err.Fields {
+		fields = append(fields, httputil.FieldError{Field: f.Field, Message: f.Message})
+	}
+
+	httputil.WriteFieldErrors(w, http.StatusUnprocessableEntity, "Invalid task spec", fields)
+}
+
+// writeSpecFieldError reports an error in what a task refers to like an
+// invalid field of its spec.
+func writeSpecFieldError(w http.ResponseWriter, err *SpecFieldError) {
+	errs := &task.ValidationError{}
+	errs.Add(err.Field, "%v", err.Err)
+	writeValidationError(w, errs)
+}
+
+// GetTasksHandler lists tasks as a JSON array. When the result is paged,
+// the cursor of the next page is returned in the X-Next-Cursor header.
+func (a *Api) GetTasksHandler(w http.ResponseWriter, r *http.Request) {
+	q, err := ParseTaskQuery(r.URL.Query())
 	if err != nil {
-		httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("No taskID passed in request: %v", err))
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	taskToStop, ok := a.Manager.TaskDb[tID]
+	namespaces, ok := auth.VisibleNamespaces(r.Context(), q.Namespaces)
 	if !ok {
-		httputil.WriteError(w, http.StatusNotFound, fmt.Sprintf("No task found with ID: %v", tID))
+		httputil.WriteJSON(w, http.StatusOK, []task.Task{})
 		return
 	}
+	q.Namespaces = namespaces
+
+	tasks, next, total := a.Manager.QueryTasks(q)
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if next != "" {
+		w.Header().Set("X-Next-Cursor", next)
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, tasks)
+}
 
-	te := task.TaskEvent{
-		ID:        uuid.New(),
-		State:     task.Completed,
-		Timestamp: time.Now(),
+func (a *Api) GetTaskHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := a.getTask(w, r)
+	if !ok {
+		return
 	}
 
-	taskCopy := *taskToStop
-	taskCopy.State = task.Completed
-	te.Task = taskCopy
+	httputil.WriteJSON(w, http.StatusOK, a.Manager.CopyTask(t))
+}
+
+func (a *Api) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
+	taskToStop, ok := a.getTask(w, r)
+	if !ok {
+		return
+	}
 
-	a.Manager.AddTask(te)
+	a.Manager.StopTask(taskToStop, "Stop requested via API")
 
 	handlerLog.WithField("task_id", taskToStop.ID).Info("Task stop requested via API")
 
 	httputil.WriteNoContent(w)
 }
+
+// KillTaskHandler kills a task without waiting for its pre-stop hook or
+// grace period.
+func (a *Api) KillTaskHandler(w http.ResponseWriter, r *http.Request) {
+	taskToKill, ok := a.getTask(w, r)
+	if !ok {
+		return
+	}
+
+	err := a.Manager.KillTask(taskToKill, "Kill requested via API")
+	switch {
+	case errors.Is(err, ErrTaskNotRunning):
+		httputil.WriteError(w, http.StatusConflict, fmt.Sprintf("Task %v is not running", taskToKill.ID))
+		return
+	case err != nil:
+		httputil.WriteError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	handlerLog.WithField("task_id", taskToKill.ID).Info("Task kill requested via API")
+
+	httputil.WriteJSON(w, http.StatusOK, a.Manager.CopyTask(taskToKill))
+}
+
+func (a *Api) PauseTaskHandler(w http.ResponseWriter, r *http.Request) {
+	a.changeTaskState(w, r, http.StatusOK, "Pause", a.Manager.PauseTask)
+}
+
+func (a *Api) ResumeTaskHandler(w http.ResponseWriter, r *http.Request) {
+	a.changeTaskState(w, r, http.StatusOK, "Resume", a.Manager.ResumeTask)
+}
+
+// RestartTaskHandler restarts a task in place, on its worker and with its
+// ID. The restart happens in the background, so it answers 202.
+func (a *Api) RestartTaskHandler(w http.ResponseWriter, r *http.Request) {
+	a.changeTaskState(w, r, http.StatusAccepted, "Restart", a.Manager.RestartTask)
+}
+
+// changeTaskState runs one of the manager's lifecycle operations on the
+// task named in the URL and returns the updated task.
+func (a *Api) changeTaskState(w http.ResponseWriter, r *http.Request, code int, operation string, change func(*task.Task, string) error) {
+	t, ok := a.getTask(w, r)
+	if !ok {
+		return
+	}
+
+	err := change(t, fmt.Sprintf("%s requested via API", operation))
+	switch {
+	case errors.Is(err, ErrTaskNotRunning), errors.Is(err, ErrInvalidStateTransition):
+		httputil.WriteError(w, http.StatusConflict, fmt.Sprintf("Cannot %s task %v: %v", strings.ToLower(operation), t.ID, err))
+		return
+	case err != nil:
+		httputil.WriteError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	handlerLog.WithField("task_id", t.ID).Infof("Task %s requested via API", strings.ToLower(operation))
+
+	httputil.WriteJSON(w, code, a.Manager.CopyTask(t))
+}
+
+// StopTasksHandler stops every active task matched by the required
+// ?selector= parameter and returns the tasks it stopped.
+func (a *Api) StopTasksHandler(w http.ResponseWriter, r *http.Request) {
+	selector, err := task.ParseSelector(r.URL.Query().Get("selector"))
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if selector.Empty() {
+		httputil.WriteError(w, http.StatusBadRequest, "A non-empty selector is required to stop tasks in bulk")
+		return
+	}
+
+	stopped := a.Manager.StopTasks(func(t *task.Task) bool {
+		return isActive(t) && selector.Matches(t.Labels) && auth.AllowedNamespace(r.Context(), namespaceOf(t))
+	}, fmt.Sprintf("Bulk stop requested via API with selector %q", r.URL.Query().Get("selector")))
+
+	handlerLog.WithField("count", len(stopped)).Info("Bulk task stop requested via API")
+
+	httputil.WriteJSON(w, http.StatusOK, stopped)
+}
+
+func (a *Api) GetTaskHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := a.getTask(w, r)
+	if !ok {
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, a.Manager.GetTaskHistory(t.ID))
+}
+
+// proxyTaskRequest passes the request on to the worker that runs the task
+// named in the URL.
+func (a *Api) proxyTaskRequest(w http.ResponseWriter, r *http.Request) {
+	t, ok := a.getTask(w, r)
+	if !ok {
+		return
+	}
+
+	worker, ok := a.Manager.taskWorker(t.ID)
+	if !ok {
+		httputil.WriteError(w, http.StatusNotFound, fmt.Sprintf("No worker found for task: %v", t.ID))
+		return
+	}
+
+	a.Manager.proxyToWorker(w, r, worker)
+}
+
+func (a *Api) GetTaskLogsHandler(w http.ResponseWriter, r *http.Request) {
+	a.proxyTaskRequest(w, r)
+}
+
+func (a *Api) ExecTaskHandler(w http.ResponseWriter, r *http.Request) {
+	handlerLog.WithField("task_id", chi.URLParam(r, "taskID")).Info("Exec into task requested via API")
+	a.proxyTaskRequest(w, r)
+}
+
+// ExecWebSocketHandler relies on the reverse proxy to pass the WebSocket
+// upgrade through to the worker.
+func (a *Api) ExecWebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	handlerLog.WithField("task_id", chi.URLParam(r, "taskID")).Info("Interactive exec into task requested via API")
+	a.proxyTaskRequest(w, r)
+}
+
+var EVENTS_KEEPALIVE_INTERVAL = 15 * time.Second
+
+type eventFilter struct {
+	taskIDs  map[uuid.UUID]bool
+	types    map[EventType]bool
+	selector task.Selector
+}
+
+func parseEventFilter(r *http.Request) (eventFilter, error) {
+	q := r.URL.Query()
+	f := eventFilter{}
+
+	for _, v := range q["task"] {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			return f, fmt.Errorf("invalid task ID %q: %w", v, err)
+		}
+		if f.taskIDs == nil {
+			f.taskIDs = make(map[uuid.UUID]bool)
+		}
+		f.taskIDs[id] = true
+	}
+
+	for _, v := range q["type"] {
+		for _, t := range strings.Split(v, ",") {
+			if f.types == nil {
+				f.types = make(map[EventType]bool)
+			}
+			f.types[EventType(t)] = true
+		}
+	}
+
+	selector, err := task.ParseSelector(q.Get("selector"))
+	if err != nil {
+		return f, err
+	}
+	f.selector = selector
+
+	return f, nil
+}
+
+func (f eventFilter) matches(e Event) bool {
+	if f.taskIDs != nil && !f.taskIDs[e.TaskID] {
+		return false
+	}
+	if f.types != nil && !f.types[e.Type] {
+		return false
+	}
+	if !f.selector.Empty() && (e.TaskID == uuid.Nil || !f.selector.Matches(e.Labels)) {
+		return false
+	}
+	return true
+}
+
+// eventVisible hides events of other namespaces from principals limited to
+// some namespaces. Such principals see no cluster-wide events either.
+func eventVisible(r *http.Request, e Event) bool {
+	if e.TaskID == uuid.Nil {
+		return auth.Unrestricted(r.Context())
+	}
+	return auth.AllowedNamespace(r.Context(), e.Namespace)
+}
+
+// GetEventsHandler streams events as Server-Sent Events. A reconnecting
+// client resumes after the last event it received by sending its ID in the
+// Last-Event-ID header, or in the ?since= query parameter.
+func (a *Api) GetEventsHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	token := r.Header.Get("Last-Event-ID")
+	if token == "" {
+		token = r.URL.Query().Get("since")
+	}
+
+	var after uint64
+	if token != "" {
+		after, err = strconv.ParseUint(token, 10, 64)
+		if err != nil {
+			httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("Invalid resume token: %q", token))
+			return
+		}
+	}
+
+	backlog, ch, complete := a.Manager.Events.Subscribe(after)
+	defer a.Manager.Events.Unsubscribe(ch)
+
+	httputil.WriteSSEHeader(w)
+
+	if !complete {
+		httputil.WriteSSE(w, "", "events.missed", "Some events after the resume token are no longer retained, or the manager has restarted")
+	}
+
+	write := func(e Event) error {
+		if !filter.matches(e) || !eventVisible(r, e) {
+			return nil
+		}
+
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+
+		return httputil.WriteSSE(w, strconv.FormatUint(e.ID, 10), string(e.Type), string(data))
+	}
+
+	for _, e := range backlog {
+		if err := write(e); err != nil {
+			return
+		}
+	}
+
+	keepalive := time.NewTicker(EVENTS_KEEPALIVE_INTERVAL)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			if err := httputil.WriteSSEComment(w, "keepalive"); err != nil {
+				return
+			}
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			if err := write(e); err != nil {
+				return
+			}
+		}
+	}
+}
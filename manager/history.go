@@ -0,0 +1,74 @@
+package manager
+
+import (
+	"Mine-Cube/task"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// recordEvent stores a task event and appends it to the task's history.
+// Events are never modified once recorded, and an event that is already
+// stored, such as a submission being re-queued, is not recorded twice.
+func (m *Manager) recordEvent(te task.TaskEvent) {
+	if _, ok := m.EventDb[te.ID]; ok {
+		return
+	}
+
+	m.EventDb[te.ID] = &te
+	m.TaskHistory[te.Task.ID] = append(m.TaskHistory[te.Task.ID], te.ID)
+}
+
+// setTaskState moves a task to a new state, records the transition in the
+// task's history and publishes it on the event stream.
+func (m *Manager) setTaskState(t *task.Task, state task.State, source task.EventSource, reason string) {
+	prev := t.State
+	t.State = state
+
+	log.WithFields(map[string]interface{}{
+		"task_id":   t.ID,
+		"old_state": prev,
+		"new_state": state,
+		"source":    source,
+	}).Info("Task state changed")
+
+	m.recordEvent(task.TaskEvent{
+		ID:        uuid.New(),
+		State:     state,
+		Timestamp: time.Now().UTC(),
+		Task:      *t,
+		Source:    source,
+		Reason:    reason,
+	})
+
+	data := map[string]interface{}{
+		"From":   prev,
+		"To":     state,
+		"Source": source,
+		"Reason": reason,
+	}
+	if t.Exit != nil && (state == task.Completed || state == task.Failed) {
+		data["Exit"] = t.Exit
+	}
+	if t.StopMode != "" && state == task.Completed {
+		data["StopMode"] = t.StopMode
+	}
+	m.publish(EventTaskStateChanged, t.ID, m.TaskWorkerMap[t.ID], data)
+}
+
+// GetTaskHistory returns copies of all events recorded for a task, oldest
+// first.
+func (m *Manager) GetTaskHistory(id uuid.UUID) []task.TaskEvent {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	history := make([]task.TaskEvent, 0, len(m.TaskHistory[id]))
+
+	for _, eventID := range m.TaskHistory[id] {
+		if te, ok := m.EventDb[eventID]; ok {
+			history = append(history, *te)
+		}
+	}
+
+	return history
+}
@@ -2,16 +2,18 @@ package manager
 
 import (
 	"Mine-Cube/logger"
+	"Mine-Cube/pki"
 	"Mine-Cube/task"
 	httputil "Mine-Cube/utils/http"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
-	"strings"
+	"sync"
 	"time"
 
-	"github.com/docker/go-connections/nat"
 	"github.com/golang-collections/collections/queue"
 	"github.com/google/uuid"
 )
@@ -22,13 +24,22 @@ var PROCESS_TASKS_INTERVAL = 10 * time.Second
 var UPDATE_TASKS_INTERVAL = 30 * time.Second
 var HEALTH_CHECK_INTERVAL = 60 * time.Second
 
+// WORKER_REQUEST_TIMEOUT bounds each request the manager makes to a worker.
+// Most are made under the manager's lock, so a worker that stops answering
+// must not hold up the API and the other loops for longer than this.
+// Requests proxied to workers, such as followed logs, are not bounded.
+var WORKER_REQUEST_TIMEOUT = 10 * time.Second
+
 type Manager struct {
 	// Pending: a queue of tasks that are waiting to be scheduled.
 	Pending queue.Queue
 	// TaskDb: a map of task names to tasks.
 	TaskDb map[uuid.UUID]*task.Task
-	// EventDb: a map of task names to task events.
+	// EventDb: a map of event IDs to task events.
 	EventDb map[uuid.UUID]*task.TaskEvent
+	// TaskHistory: a map of task IDs to the IDs of their events, in the
+	// order they were recorded.
+	TaskHistory map[uuid.UUID][]uuid.UUID
 	// Workers: a list of worker names.
 	Workers []string
 	// WorkerTaskMap: a map of worker names to task IDs.
@@ -37,10 +48,74 @@ type Manager struct {
 	TaskWorkerMap map[uuid.UUID]string
 	// Index into worker slice
 	LastWorker int
+	// WorkerStatus: a map of worker names to whether they were reachable
+	// on the last update ("up" or "down").
+	WorkerStatus map[string]string
+	// Events: the stream of task and node events
+	Events *EventBroker
+	// NamespaceDb: a map of namespace names to namespaces.
+	NamespaceDb map[string]*Namespace
+	// ServiceToken: the credential the manager presents to workers.
+	ServiceToken string
+	// ConfigDb: a map of "namespace/name" to configs tasks can mount.
+	ConfigDb map[string]*Config
+	// VolumeDb: a map of "namespace/name" to named volumes.
+	VolumeDb map[string]*Volume
+	// ServiceDb: a map of "namespace/name" to service specs.
+	ServiceDb map[string]*Service
+	// SpecRevisions: a map of task IDs to the revisions of their specs,
+	// oldest first.
+	SpecRevisions map[uuid.UUID][]SpecRevision
+	// Secrets: encrypted secrets tasks can refer to; nil when no master
+	// key is configured.
+	Secrets *SecretStore
+	// CA: issues worker certificates when TLS is enabled.
+	CA *pki.CA
+	// KeyPair: the manager's own certificate when TLS is enabled.
+	KeyPair *pki.KeyPair
+	// WorkerCertHosts: the names and IPs workers may register at. A
+	// worker's certificate is issued for the host of its address only.
+	WorkerCertHosts []string
+
+	// mu keeps the background loops from changing tasks and workers at
+	// the same time as each other, the DNS server and the ingress proxy.
+	mu sync.RWMutex
+
+	certHosts []string
+	client    *http.Client
+	scheme    string
 }
 
 var MAX_RESTART_COUNT = 3
 
+// UNHEALTHY_THRESHOLD is how many health probes in a row must fail before
+// the manager restarts a running task.
+var UNHEALTHY_THRESHOLD = 3
+
+var ErrTaskExists = errors.New("task already exists")
+var ErrTaskNotRunning = errors.New("task is not running")
+var ErrInvalidStateTransition = errors.New("invalid state transition")
+
+// SpecFieldError ties an error in what a task refers to, such as a secret
+// that does not exist, to the field of the spec it comes from, such as
+// "Secrets[0]".
+type SpecFieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *SpecFieldError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Field, e.Err)
+}
+
+func (e *SpecFieldError) Unwrap() error {
+	return e.Err
+}
+
+func specFieldError(field string, err error) error {
+	return &SpecFieldError{Field: field, Err: err}
+}
+
 func NewManager(workers []string) *Manager {
 	taskDb := make(map[uuid.UUID]*task.Task)
 	eventDb := make(map[uuid.UUID]*task.TaskEvent)
@@ -56,8 +131,20 @@ func NewManager(workers []string) *Manager {
 		Workers:       workers,
 		TaskDb:        taskDb,
 		EventDb:       eventDb,
+		TaskHistory:   make(map[uuid.UUID][]uuid.UUID),
 		WorkerTaskMap: workerTaskMap,
 		TaskWorkerMap: taskWorkerMap,
+		WorkerStatus:  make(map[string]string),
+		Events:        NewEventBroker(),
+		ConfigDb:      make(map[string]*Config),
+		VolumeDb:      make(map[string]*Volume),
+		ServiceDb:     make(map[string]*Service),
+		SpecRevisions: make(map[uuid.UUID][]SpecRevision),
+		client:        &http.Client{Timeout: WORKER_REQUEST_TIMEOUT},
+		scheme:        "http",
+		NamespaceDb: map[string]*Namespace{
+			task.DefaultNamespace: {Name: task.DefaultNamespace, CreatedAt: time.Now().UTC()},
+		},
 	}
 }
 
@@ -78,23 +165,31 @@ func (m *Manager) SelectWorker() string {
 }
 
 func (m *Manager) updateTasks() {
-	for _, worker := range m.Workers {
-		log.WithField("worker", worker).Debug("Checking worker for task updates")
+	m.mu.RLock()
+	workers := append([]string{}, m.Workers...)
+	m.mu.RUnlock()
 
-		url := fmt.Sprintf("http://%s/tasks", worker)
+	for _, worker := range workers {
+		log.WithField("worker", worker).Debug("Checking worker for task updates")
 
-		resp, err := http.Get(url)
+		// the worker is asked without holding the lock, so that a slow
+		// worker does not hold up DNS and ingress lookups
+		resp, err := m.workerRequest(http.MethodGet, worker, "/tasks", nil)
 
 		if err != nil {
 			log.WithField("worker", worker).Warnf("Error connecting to worker: %v", err)
+			m.setWorkerStatus(worker, "down", err.Error())
 			continue
 		}
 
 		if resp.StatusCode != http.StatusOK {
 			log.WithField("worker", worker).Warnf("Non-OK response from worker: %d", resp.StatusCode)
+			m.setWorkerStatus(worker, "down", fmt.Sprintf("status code %d", resp.StatusCode))
 			continue
 		}
 
+		m.setWorkerStatus(worker, "up", "")
+
 		d := json.NewDecoder(resp.Body)
 
 		var tasks []*task.Task
@@ -105,28 +200,97 @@ func (m *Manager) updateTasks() {
 			continue
 		}
 
+		m.mu.Lock()
 		for _, t := range tasks {
-			log.WithField("task_id", t.ID).Debug("Updating task from worker")
-			_, ok := m.TaskDb[t.ID]
+			m.updateTask(worker, t)
+		}
+		m.mu.Unlock()
+	}
+}
 
-			if !ok {
-				log.WithField("task_id", t.ID).Error("Task not found in database")
-				continue
-			}
+// updateTask copies what a worker reports about a task into TaskDb. The
+// caller holds m.mu.
+func (m *Manager) updateTask(worker string, t *task.Task) {
+	log.WithField("task_id", t.ID).Debug("Updating task from worker")
+	_, ok := m.TaskDb[t.ID]
 
-			if m.TaskDb[t.ID].State != t.State {
-				log.WithFields(map[string]interface{}{
-					"task_id":   t.ID,
-					"old_state": m.TaskDb[t.ID].State,
-					"new_state": t.State,
-				}).Info("Task state changed")
-				m.TaskDb[t.ID].State = t.State
-			}
+	if !ok {
+		log.WithField("task_id", t.ID).Error("Task not found in database")
+		return
+	}
+
+	// both change where the task can be reached, so they are
+	// published for service discovery
+	portsChanged := !samePorts(m.TaskDb[t.ID].HostPorts, t.HostPorts)
+	healthChanged := m.TaskDb[t.ID].Health.Status != t.Health.Status
+
+	m.TaskDb[t.ID].StartTime = t.StartTime
+	m.TaskDb[t.ID].FinishTime = t.FinishTime
+	m.TaskDb[t.ID].ContainerID = t.ContainerID
+	m.TaskDb[t.ID].HostPorts = t.HostPorts
+	m.TaskDb[t.ID].Health = t.Health
+	m.TaskDb[t.ID].Exit = t.Exit
+	m.TaskDb[t.ID].StopMode = t.StopMode
+	// restarts asked of the worker directly are counted there
+	if t.RestartCount > m.TaskDb[t.ID].RestartCount {
+		m.TaskDb[t.ID].RestartCount = t.RestartCount
+	}
+
+	if portsChanged {
+		m.publish(EventTaskPortsChanged, t.ID, worker, map[string]interface{}{
+			"HostPorts": t.HostPorts,
+		})
+	}
+	if healthChanged {
+		m.publish(EventTaskHealthChanged, t.ID, worker, map[string]interface{}{
+			"Status":  t.Health.Status,
+			"Message": t.Health.Message,
+		})
+	}
+
+	if m.TaskDb[t.ID].State != t.State {
+		reason := t.StateReason
+		if m.TaskDb[t.ID].State == task.Lost {
+			reason = fmt.Sprintf("Worker %s is reachable again", worker)
+		} else if reason == "" {
+			reason = "Reported by worker"
+		}
+		m.setTaskState(m.TaskDb[t.ID], t.State, task.SourceWorker, reason)
+	}
+}
+
+// setWorkerStatus records whether a worker could be reached and publishes
+// an event when that changes.
+func (m *Manager) setWorkerStatus(worker string, status string, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prev, ok := m.WorkerStatus[worker]
+	if ok && prev == status {
+		return
+	}
 
-			m.TaskDb[t.ID].StartTime = t.StartTime
-			m.TaskDb[t.ID].FinishTime = t.FinishTime
-			m.TaskDb[t.ID].ContainerID = t.ContainerID
-			m.TaskDb[t.ID].HostPorts = t.HostPorts
+	m.WorkerStatus[worker] = status
+
+	log.WithFields(map[string]interface{}{
+		"worker": worker,
+		"status": status,
+	}).Info("Worker status changed")
+
+	data := map[string]interface{}{"Status": status}
+	if reason != "" {
+		data["Reason"] = reason
+	}
+	m.publish(EventNodeStatusChanged, uuid.Nil, worker, data)
+
+	// the tasks of an unreachable worker may or may not still run; they
+	// get their state back when the worker reports again
+	if status == "down" {
+		for _, id := range m.WorkerTaskMap[worker] {
+			t, ok := m.TaskDb[id]
+			if ok && task.ValidStateTransition(t.State, task.Lost) {
+				m.setTaskState(t, task.Lost, task.SourceNode, fmt.Sprintf("Worker %s is unreachable: %s", worker, reason))
+			}
 		}
 	}
 }
@@ -137,31 +301,63 @@ func (m *Manager) SendWork() {
 		return
 	}
 
-	w := m.SelectWorker()
-
 	e := m.Pending.Dequeue()
 	te := e.(task.TaskEvent)
 	t := te.Task
-	log.WithFields(map[string]interface{}{
-		"task_id": t.ID,
-		"worker":  w,
-	}).Info("Scheduling task to worker")
 
-	m.EventDb[te.ID] = &te
-	m.WorkerTaskMap[w] = append(m.WorkerTaskMap[w], te.Task.ID)
-	m.TaskWorkerMap[t.ID] = w
+	// Events for tasks that already run somewhere, such as stop requests,
+	// go to the worker that owns the task
+	w, assigned := m.TaskWorkerMap[t.ID]
+	if !assigned {
+		// tasks using a named volume go where its data is, and tasks
+		// joining a network go where the network's other tasks are
+		var err error
+		w, err = m.volumeWorker(&t)
+		if err == nil {
+			w, err = m.networkWorker(&t, w)
+		}
+		if err != nil {
+			log.WithField("task_id", t.ID).Errorf("Failed to schedule task: %v", err)
+			if stored, ok := m.TaskDb[t.ID]; ok {
+				m.setTaskState(stored, task.Failed, task.SourceScheduler, err.Error())
+			}
+			return
+		}
+		if w == "" {
+			w = m.SelectWorker()
+		}
 
-	t.State = task.Scheduled
-	m.TaskDb[t.ID] = &t
+		log.WithFields(map[string]interface{}{
+			"task_id": t.ID,
+			"worker":  w,
+		}).Info("Scheduling task to worker")
+
+		m.WorkerTaskMap[w] = append(m.WorkerTaskMap[w], te.Task.ID)
+		m.TaskWorkerMap[t.ID] = w
+		m.bindVolumes(&t, w)
+
+		stored, ok := m.TaskDb[t.ID]
+		if !ok {
+			pending := t
+			pending.State = task.Pending
+			stored = &pending
+			m.TaskDb[t.ID] = stored
+		}
 
-	data, err := json.Marshal(te)
+		m.publish(EventTaskScheduled, t.ID, w, nil)
+		m.setTaskState(stored, task.Scheduled, task.SourceScheduler, fmt.Sprintf("Assigned to worker %s", w))
+	}
+
+	data, err := m.encodeWork(te)
 	if err != nil {
-		log.WithField("task_id", t.ID).Errorf("Failed to marshal task: %v", err)
+		log.WithField("task_id", t.ID).Errorf("Failed to prepare task for worker: %v", err)
+		if stored, ok := m.TaskDb[t.ID]; ok && te.State != task.Completed {
+			m.setTaskState(stored, task.Failed, task.SourceScheduler, err.Error())
+		}
 		return
 	}
 
-	url := fmt.Sprintf("http://%s/tasks", w)
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
+	resp, err := m.postWork(w, te.ID, data)
 
 	if err != nil {
 		log.WithFields(map[string]interface{}{
@@ -202,148 +398,406 @@ func (m *Manager) SendWork() {
 }
 
 func (m *Manager) AddTask(te task.TaskEvent) {
+	m.recordEvent(te)
 	m.Pending.Enqueue(te)
 }
 
-func (m *Manager) GetTasks() []*task.Task {
-	tasks := []*task.Task{}
+// ValidateSpec checks a submission, including that its name, which is also
+// its container's name, is not used by another active task in any
+// namespace.
+func (m *Manager) ValidateSpec(spec *task.TaskSpec) error {
+	errs := spec.Validate()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	for _, t := range m.TaskDb {
-		tasks = append(tasks, t)
+		if t.Name == spec.Name && t.ID != spec.ID && isActive(t) {
+			errs.Add("Name", "is already used by task %s", t.ID)
+			break
+		}
 	}
-	return tasks
+
+	return errs.Err()
 }
 
-func (m *Manager) UpdateTasks() {
-	for {
-		log.WithFields(map[string]interface{}{
-			"interval":     UPDATE_TASKS_INTERVAL,
-			"worker_count": len(m.Workers),
-			"task_count":   len(m.TaskDb),
-		}).Debug("Checking for task updates from workers")
+// SubmitTask admits a new task into its namespace and queues it for
+// scheduling. The task is recorded as Pending right away so that it counts
+// towards the namespace's quota before it reaches a worker.
+func (m *Manager) SubmitTask(te task.TaskEvent) error {
+	t := te.Task
 
-		m.updateTasks()
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-		time.Sleep(UPDATE_TASKS_INTERVAL)
+	// a task ID is only ever submitted once; retries that must not start
+	// a task twice carry an Idempotency-Key instead
+	if _, ok := m.TaskDb[t.ID]; ok {
+		return ErrTaskExists
+	}
+	if t.Namespace == "" {
+		t.Namespace = task.DefaultNamespace
+	}
+
+	if err := m.checkQuota(&t); err != nil {
+		return err
+	}
+
+	if err := m.validateSecretRefs(&t); err != nil {
+		return err
+	}
+
+	if err := m.resolveConfigRefs(&t); err != nil {
+		return err
+	}
+
+	if err := m.validateVolumes(&t); err != nil {
+		return err
 	}
+
+	if err := validateNetworks(&t); err != nil {
+		return err
+	}
+
+	t.State = task.Pending
+	t.Revision = 1
+	m.TaskDb[t.ID] = &t
+	m.recordRevision(&t, nil)
+
+	te.Task.Namespace = t.Namespace
+	te.Task.Configs = t.Configs
+	te.Task.Revision = t.Revision
+	m.AddTask(te)
+
+	return nil
 }
 
-func (m *Manager) ProcessTasks() {
-	for {
-		log.WithFields(map[string]interface{}{
-			"interval":    PROCESS_TASKS_INTERVAL,
-			"pending_len": m.Pending.Len(),
-			"task_count":  len(m.TaskDb),
-		}).Debug("Processing tasks in queue")
+// StopTask queues a request to stop the task on its worker.
+// A running task is Stopping until its worker reports it Completed.
+func (m *Manager) StopTask(t *task.Task, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-		m.SendWork()
+	m.stopTask(t, reason)
+}
 
-		time.Sleep(PROCESS_TASKS_INTERVAL)
+// StopTasks stops every task for which match returns true and returns
+// copies of the tasks it stopped.
+func (m *Manager) StopTasks(match func(t *task.Task) bool, reason string) []task.Task {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stopped := []task.Task{}
+	for _, t := range m.TaskDb {
+		if !match(t) {
+			continue
+		}
+
+		m.stopTask(t, reason)
+		stopped = append(stopped, *t)
+	}
+
+	return stopped
+}
+
+// stopTask is StopTask for callers that hold m.mu.
+func (m *Manager) stopTask(t *task.Task, reason string) {
+	taskCopy := *t
+	taskCopy.State = task.Completed
+
+	te := task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Completed,
+		Timestamp: time.Now(),
+		Task:      taskCopy,
+		Source:    task.SourceAPI,
+		Reason:    reason,
+	}
+
+	if task.ValidStateTransition(t.State, task.Stopping) {
+		m.setTaskState(t, task.Stopping, task.SourceAPI, reason)
+		m.Pending.Enqueue(te)
+		return
 	}
+
+	m.AddTask(te)
 }
 
-func getHostPort(ports nat.PortMap) *string {
-	for k, _ := range ports {
-		return &ports[k][0].HostPort
+// KillTask has the task's worker kill its container right away, skipping
+// the task's pre-stop hook and grace period, including when a stop is
+// already waiting out the grace period. Tasks that have not reached a
+// worker yet are only taken off the queue, as StopTask does.
+func (m *Manager) KillTask(t *task.Task, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, assigned := m.TaskWorkerMap[t.ID]; !assigned {
+		m.stopTask(t, reason)
+		return nil
 	}
+
+	if t.State != task.Running && t.State != task.Stopping && t.State != task.Paused {
+		return ErrTaskNotRunning
+	}
+
+	killed, err := m.taskAction(t, "kill")
+	if err != nil {
+		return err
+	}
+
+	t.FinishTime = killed.FinishTime
+	t.Exit = killed.Exit
+	t.StopMode = killed.StopMode
+	m.setTaskState(t, task.Completed, task.SourceAPI, reason)
+
 	return nil
 }
 
-func (m *Manager) checkTaskHealth(t task.Task) error {
-	// Skip health check if no health check endpoint is configured
-	if t.HealthCheck == "" {
-		log.WithField("task_id", t.ID).Debug("No health check endpoint configured, skipping")
-		return nil
+// PauseTask freezes the processes of a running task's container.
+func (m *Manager) PauseTask(t *task.Task, reason string) error {
+	return m.changeTaskState(t, task.Paused, "pause", reason)
+}
+
+// ResumeTask lets a paused task's processes run again.
+func (m *Manager) ResumeTask(t *task.Task, reason string) error {
+	return m.changeTaskState(t, task.Running, "resume", reason)
+}
+
+func (m *Manager) changeTaskState(t *task.Task, state task.State, action string, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, assigned := m.TaskWorkerMap[t.ID]; !assigned {
+		return ErrTaskNotRunning
+	}
+
+	if t.State == state || !task.ValidStateTransition(t.State, state) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidStateTransition, t.State, state)
+	}
+
+	if _, err := m.taskAction(t, action); err != nil {
+		return err
 	}
 
+	m.setTaskState(t, state, task.SourceAPI, reason)
+
+	return nil
+}
+
+// RestartTask replaces the task's container with a new one on the same
+// worker. The task keeps its ID and its RestartCount goes up by one, which
+// does not use up the restarts MAX_RESTART_COUNT allows after failures.
+func (m *Manager) RestartTask(t *task.Task, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, assigned := m.TaskWorkerMap[t.ID]; !assigned {
+		return ErrTaskNotRunning
+	}
+
+	if !task.ValidStateTransition(t.State, task.Restarting) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidStateTransition, t.State, task.Restarting)
+	}
+
+	m.restartTask(t, task.SourceAPI, reason)
+
+	return nil
+}
+
+// taskAction asks the worker that owns the task to act on it right away,
+// and returns the task as the worker reports it afterwards.
+func (m *Manager) taskAction(t *task.Task, action string) (task.Task, error) {
+	return m.taskRequest(t, http.MethodPost, "/"+action, nil, action)
+}
+
+// taskRequest sends a request about the task to the worker that owns it,
+// at path below the task's URL, and decodes the task from the response.
+func (m *Manager) taskRequest(t *task.Task, method string, path string, data []byte, action string) (task.Task, error) {
 	w := m.TaskWorkerMap[t.ID]
-	hostPort := getHostPort(t.HostPorts)
 
-	// Skip health check if no host port is available
-	if hostPort == nil {
-		log.WithField("task_id", t.ID).Warn("No host port available for health check, skipping")
-		return nil
+	var body io.Reader
+	if data != nil {
+		body = bytes.NewReader(data)
 	}
 
-	worker := strings.Split(w, ":")
+	resp, err := m.workerRequest(method, w, fmt.Sprintf("/tasks/%s%s", t.ID, path), body)
+	if err != nil {
+		return task.Task{}, fmt.Errorf("error connecting to worker %s: %w", w, err)
+	}
+	defer resp.Body.Close()
 
-	url := fmt.Sprintf("http://%s:%s%s", worker[0], *hostPort, t.HealthCheck)
+	d := json.NewDecoder(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		e := httputil.ErrorResponse{}
+		if err := d.Decode(&e); err != nil {
+			return task.Task{}, fmt.Errorf("worker %s could not %s the task (%d)", w, action, resp.StatusCode)
+		}
+		return task.Task{}, fmt.Errorf("worker %s could not %s the task: %s", w, action, e.Message)
+	}
+
+	updated := task.Task{}
+	if err := d.Decode(&updated); err != nil {
+		return task.Task{}, fmt.Errorf("error decoding worker response: %w", err)
+	}
 
 	log.WithFields(map[string]interface{}{
 		"task_id": t.ID,
-		"url":     url,
-	}).Debug("Calling health check endpoint")
+		"worker":  w,
+		"action":  action,
+	}).Info("Worker acted on task")
 
-	resp, err := http.Get(url)
-	if err != nil {
-		log.WithFields(map[string]interface{}{
-			"task_id": t.ID,
-			"url":     url,
-		}).Warnf("Health check failed: %v", err)
-		return fmt.Errorf("health check connection error: %w", err)
+	return updated, nil
+}
+
+// GetTask looks up a task by its ID.
+func (m *Manager) GetTask(id uuid.UUID) (*task.Task, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	t, ok := m.TaskDb[id]
+	return t, ok
+}
+
+// taskWorker returns the worker a task has been sent to.
+func (m *Manager) taskWorker(id uuid.UUID) (string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	w, ok := m.TaskWorkerMap[id]
+	return w, ok
+}
+
+// CopyTask returns a copy of t taken under the manager's lock, which can be
+// read while the manager goes on changing t.
+func (m *Manager) CopyTask(t *task.Task) task.Task {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return *t
+}
+
+// GetTasks returns copies of all tasks.
+func (m *Manager) GetTasks() []task.Task {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tasks := []task.Task{}
+	for _, t := range m.TaskDb {
+		tasks = append(tasks, *t)
 	}
+	return tasks
+}
 
-	if resp.StatusCode != http.StatusOK {
+func (m *Manager) UpdateTasks() {
+	for {
+		m.mu.RLock()
 		log.WithFields(map[string]interface{}{
-			"task_id":     t.ID,
-			"status_code": resp.StatusCode,
-		}).Warn("Health check returned non-OK status")
-		return fmt.Errorf("health check returned status %d", resp.StatusCode)
+			"interval":     UPDATE_TASKS_INTERVAL,
+			"worker_count": len(m.Workers),
+			"task_count":   len(m.TaskDb),
+		}).Debug("Checking for task updates from workers")
+		m.mu.RUnlock()
+
+		m.updateTasks()
+
+		time.Sleep(UPDATE_TASKS_INTERVAL)
 	}
+}
+
+func (m *Manager) ProcessTasks() {
+	for {
+		m.mu.RLock()
+		log.WithFields(map[string]interface{}{
+			"interval":    PROCESS_TASKS_INTERVAL,
+			"pending_len": m.Pending.Len(),
+			"task_count":  len(m.TaskDb),
+		}).Debug("Processing tasks in queue")
+		m.mu.RUnlock()
 
-	log.WithField("task_id", t.ID).Debug("Health check passed")
+		m.mu.Lock()
+		m.SendWork()
+		m.mu.Unlock()
 
-	return nil
+		time.Sleep(PROCESS_TASKS_INTERVAL)
+	}
 }
 
 func (m *Manager) doHealthChecks() {
-	for _, t := range m.GetTasks() {
-		if t.State == task.Running && t.RestartCount < MAX_RESTART_COUNT {
-			err := m.checkTaskHealth(*t)
-			if err != nil {
-				if t.RestartCount < MAX_RESTART_COUNT {
-					m.restartTask(t)
-				}
-			}
-		} else if t.State == task.Failed && t.RestartCount < MAX_RESTART_COUNT {
-			m.restartTask(t)
+	for _, t := range m.TaskDb {
+		if t.FailureRestarts >= MAX_RESTART_COUNT {
+			continue
+		}
+
+		if t.State == task.Running && t.Health.Status == task.Unhealthy && t.Health.Failures >= UNHEALTHY_THRESHOLD {
+			log.WithFields(map[string]interface{}{
+				"task_id":  t.ID,
+				"failures": t.Health.Failures,
+				"message":  t.Health.Message,
+			}).Warn("Worker reported task as unhealthy")
+			m.publish(EventTaskHealthCheckFail, t.ID, m.TaskWorkerMap[t.ID], map[string]interface{}{
+				"Failures": t.Health.Failures,
+				"Message":  t.Health.Message,
+			})
+			t.FailureRestarts++
+			m.restartTask(t, task.SourceHealthCheck, fmt.Sprintf("Restarted after failed health check: %s", t.Health.Message))
+		} else if t.State == task.Failed {
+			t.FailureRestarts++
+			m.restartTask(t, task.SourceRestartPolicy, "Restarted after task failed")
 		}
 	}
 }
 
-func (m *Manager) restartTask(t *task.Task) {
+func (m *Manager) restartTask(t *task.Task, source task.EventSource, reason string) {
 	w := m.TaskWorkerMap[t.ID]
-	t.State = task.Scheduled
 	t.RestartCount++
+	t.Health = task.Health{}
 	m.TaskDb[t.ID] = t
 
+	m.publish(EventTaskRestarted, t.ID, w, map[string]interface{}{
+		"RestartCount": t.RestartCount,
+	})
+
 	log.WithFields(map[string]interface{}{
 		"task_id":       t.ID,
 		"restart_count": t.RestartCount,
 		"worker":        w,
 	}).Info("Restarting task")
 
+	m.replaceContainer(t, source, reason)
+}
+
+// replaceContainer has the task's worker replace its container with a new
+// one started from the task as it is now.
+func (m *Manager) replaceContainer(t *task.Task, source task.EventSource, reason string) {
+	w := m.TaskWorkerMap[t.ID]
+	m.setTaskState(t, task.Restarting, source, reason)
+
+	// workers act on the state of the task they are sent, which is now
+	// Restarting, whether the task was running or had failed
 	te := task.TaskEvent{
 		ID:        uuid.New(),
-		State:     task.Running,
+		State:     task.Restarting,
 		Timestamp: time.Now(),
 		Task:      *t,
+		Source:    source,
+		Reason:    reason,
 	}
 
-	data, err := json.Marshal(te)
+	data, err := m.encodeWork(te)
 	if err != nil {
-		log.WithField("task_id", t.ID).Errorf("Failed to marshal task for restart: %v", err)
+		log.WithField("task_id", t.ID).Errorf("Failed to prepare task for restart: %v", err)
+		m.setTaskState(t, task.Failed, source, err.Error())
 		return
 	}
 
-	url := fmt.Sprintf("http://%s/tasks", w)
-
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
+	resp, err := m.postWork(w, te.ID, data)
 	if err != nil {
 		log.WithFields(map[string]interface{}{
 			"task_id": t.ID,
 			"worker":  w,
 		}).Warnf("Failed to restart task, re-queueing: %v", err)
-		m.Pending.Enqueue(t)
+		m.AddTask(te)
 		return
 	}
 
@@ -378,12 +832,16 @@ func (m *Manager) restartTask(t *task.Task) {
 
 func (m *Manager) DoHealthChecks() {
 	for {
+		m.mu.RLock()
 		log.WithFields(map[string]interface{}{
 			"interval":   HEALTH_CHECK_INTERVAL,
 			"task_count": len(m.TaskDb),
 		}).Debug("Performing task health checks")
+		m.mu.RUnlock()
 
+		m.mu.Lock()
 		m.doHealthChecks()
+		m.mu.Unlock()
 
 		time.Sleep(HEALTH_CHECK_INTERVAL)
 	}
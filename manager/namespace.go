@@ -0,0 +1,270 @@
+package manager
+
+import (
+	"Mine-Cube/task"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+)
+
+var namespaceNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+var ErrNamespaceNotFound = errors.New("namespace not found")
+var ErrNamespaceExists = errors.New("namespace already exists")
+var ErrNamespaceInUse = errors.New("namespace still has active tasks")
+var ErrNamespaceHasVolumes = errors.New("namespace still has volumes")
+
+// Quota limits the resources that the active tasks of a namespace may
+// request in total. A zero limit means unlimited.
+type Quota struct {
+	Cpu    float64
+	Memory int64
+	Disk   int64
+	Tasks  int
+}
+
+type Namespace struct {
+	Name      string
+	Quota     Quota
+	CreatedAt time.Time
+}
+
+// NamespaceStatus reports a namespace's current usage against its quota.
+type NamespaceStatus struct {
+	Namespace
+	Used Quota
+}
+
+// QuotaExceededError is returned when admitting a task would take a
+// namespace past one of its limits.
+type QuotaExceededError struct {
+	Namespace string
+	Resource  string
+	Requested interface{}
+	Used      interface{}
+	Limit     interface{}
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("quota exceeded in namespace %q: %s requested %v, %v already used of %v",
+		e.Namespace, e.Resource, e.Requested, e.Used, e.Limit)
+}
+
+func ValidateNamespaceName(name string) error {
+	if len(name) > 63 || !namespaceNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid namespace name %q: must be a lowercase DNS label of at most 63 characters", name)
+	}
+	return nil
+}
+
+// isActive reports whether a task still holds resources and counts towards
+// its namespace's quota.
+func isActive(t *task.Task) bool {
+	return t.State != task.Completed && t.State != task.Failed && t.State != task.Preempted
+}
+
+func namespaceOf(t *task.Task) string {
+	if t.Namespace == "" {
+		return task.DefaultNamespace
+	}
+	return t.Namespace
+}
+
+func (m *Manager) CreateNamespace(ns Namespace) (*Namespace, error) {
+	if err := ValidateNamespaceName(ns.Name); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.NamespaceDb[ns.Name]; ok {
+		return nil, ErrNamespaceExists
+	}
+
+	ns.CreatedAt = time.Now().UTC()
+	created := ns
+	m.NamespaceDb[ns.Name] = &created
+
+	log.WithField("namespace", ns.Name).Info("Namespace created")
+
+	return &ns, nil
+}
+
+func (m *Manager) UpdateQuota(name string, quota Quota) (*Namespace, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ns, ok := m.NamespaceDb[name]
+	if !ok {
+		return nil, ErrNamespaceNotFound
+	}
+
+	ns.Quota = quota
+
+	log.WithField("namespace", name).Info("Namespace quota updated")
+
+	updated := *ns
+	return &updated, nil
+}
+
+func (m *Manager) DeleteNamespace(name string) error {
+	if name == task.DefaultNamespace {
+		return fmt.Errorf("the %s namespace cannot be deleted", task.DefaultNamespace)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.NamespaceDb[name]; !ok {
+		return ErrNamespaceNotFound
+	}
+
+	if m.NamespaceUsage(name).Tasks > 0 {
+		return ErrNamespaceInUse
+	}
+
+	// volumes hold data, so they have to be deleted explicitly
+	for _, v := range m.VolumeDb {
+		if v.Namespace == name {
+			return ErrNamespaceHasVolumes
+		}
+	}
+
+	delete(m.NamespaceDb, name)
+
+	for key, c := range m.ConfigDb {
+		if c.Namespace == name {
+			delete(m.ConfigDb, key)
+		}
+	}
+
+	for key, s := range m.ServiceDb {
+		if s.Namespace == name {
+			delete(m.ServiceDb, key)
+		}
+	}
+
+	if m.Secrets != nil {
+		for _, secret := range m.Secrets.List(name) {
+			if err := m.Secrets.Delete(name, secret.Name); err != nil {
+				log.WithField("namespace", name).Warnf("Error deleting secret %s: %v", secret.Name, err)
+			}
+		}
+	}
+
+	log.WithField("namespace", name).Info("Namespace deleted")
+
+	return nil
+}
+
+// NamespaceUsage adds up the resources requested by the active tasks of a
+// namespace. The caller holds m.mu.
+func (m *Manager) NamespaceUsage(name string) Quota {
+	used := Quota{}
+
+	for _, t := range m.TaskDb {
+		if namespaceOf(t) != name || !isActive(t) {
+			continue
+		}
+
+		used.Cpu += t.Cpu
+		used.Memory += t.Memory
+		used.Disk += t.Disk
+		used.Tasks++
+	}
+
+	return used
+}
+
+func (m *Manager) GetNamespace(name string) (NamespaceStatus, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ns, ok := m.NamespaceDb[name]
+	if !ok {
+		return NamespaceStatus{}, ErrNamespaceNotFound
+	}
+
+	return NamespaceStatus{Namespace: *ns, Used: m.NamespaceUsage(name)}, nil
+}
+
+func (m *Manager) GetNamespaces() []NamespaceStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	namespaces := make([]NamespaceStatus, 0, len(m.NamespaceDb))
+
+	for name, ns := range m.NamespaceDb {
+		namespaces = append(namespaces, NamespaceStatus{Namespace: *ns, Used: m.NamespaceUsage(name)})
+	}
+
+	sort.Slice(namespaces, func(i, j int) bool {
+		return namespaces[i].Name < namespaces[j].Name
+	})
+
+	return namespaces
+}
+
+// checkQuota returns a *QuotaExceededError if admitting t would take its
+// namespace over quota.
+func (m *Manager) checkQuota(t *task.Task) error {
+	name := namespaceOf(t)
+
+	ns, ok := m.NamespaceDb[name]
+	if !ok {
+		return ErrNamespaceNotFound
+	}
+
+	q := ns.Quota
+	used := m.NamespaceUsage(name)
+
+	if q.Tasks > 0 && used.Tasks+1 > q.Tasks {
+		return &QuotaExceededError{Namespace: name, Resource: "tasks", Requested: 1, Used: used.Tasks, Limit: q.Tasks}
+	}
+	if q.Cpu > 0 && used.Cpu+t.Cpu > q.Cpu {
+		return &QuotaExceededError{Namespace: name, Resource: "cpu", Requested: t.Cpu, Used: used.Cpu, Limit: q.Cpu}
+	}
+	if q.Memory > 0 && used.Memory+t.Memory > q.Memory {
+		return &QuotaExceededError{Namespace: name, Resource: "memory", Requested: t.Memory, Used: used.Memory, Limit: q.Memory}
+	}
+	if q.Disk > 0 && used.Disk+t.Disk > q.Disk {
+		return &QuotaExceededError{Namespace: name, Resource: "disk", Requested: t.Disk, Used: used.Disk, Limit: q.Disk}
+	}
+
+	return nil
+}
+
+// checkQuotaChange is checkQuota for an active task whose resources change
+// from those of prev to those of t. Only increases are checked, so that a
+// namespace over its quota can still shrink its tasks.
+func (m *Manager) checkQuotaChange(prev *task.Task, t *task.Task) error {
+	name := namespaceOf(prev)
+
+	ns, ok := m.NamespaceDb[name]
+	if !ok {
+		return ErrNamespaceNotFound
+	}
+
+	q := ns.Quota
+	used := m.NamespaceUsage(name)
+	if isActive(prev) {
+		used.Cpu -= prev.Cpu
+		used.Memory -= prev.Memory
+		used.Disk -= prev.Disk
+	}
+
+	if q.Cpu > 0 && t.Cpu > prev.Cpu && used.Cpu+t.Cpu > q.Cpu {
+		return &QuotaExceededError{Namespace: name, Resource: "cpu", Requested: t.Cpu, Used: used.Cpu, Limit: q.Cpu}
+	}
+	if q.Memory > 0 && t.Memory > prev.Memory && used.Memory+t.Memory > q.Memory {
+		return &QuotaExceededError{Namespace: name, Resource: "memory", Requested: t.Memory, Used: used.Memory, Limit: q.Memory}
+	}
+	if q.Disk > 0 && t.Disk > prev.Disk && used.Disk+t.Disk > q.Disk {
+		return &QuotaExceededError{Namespace: name, Resource: "disk", Requested: t.Disk, Used: used.Disk, Limit: q.Disk}
+	}
+
+	return nil
+}
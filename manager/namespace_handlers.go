@@ -0,0 +1,144 @@
+package manager
+
+import (
+	"Mine-Cube/auth"
+	httputil "Mine-Cube/utils/http"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+type namespaceRequest struct {
+	Name  string
+	Quota Quota
+}
+
+// CreateNamespaceHandler creates a namespace. Principals limited to some
+// namespaces may not create others.
+func (a *Api) CreateNamespaceHandler(w http.ResponseWriter, r *http.Request) {
+	if !auth.Unrestricted(r.Context()) {
+		httputil.WriteError(w, http.StatusForbidden, "Only principals unrestricted by namespace may create namespaces")
+		return
+	}
+
+	req, err := httputil.DecodeJSON[namespaceRequest](r)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("%v", err))
+		return
+	}
+
+	ns, err := a.Manager.CreateNamespace(Namespace{Name: req.Name, Quota: req.Quota})
+	if errors.Is(err, ErrNamespaceExists) {
+		httputil.WriteError(w, http.StatusConflict, fmt.Sprintf("Namespace already exists: %s", req.Name))
+		return
+	}
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	handlerLog.WithField("namespace", ns.Name).Info("Namespace created via API")
+	httputil.WriteJSON(w, http.StatusCreated, NamespaceStatus{Namespace: *ns})
+}
+
+func (a *Api) GetNamespacesHandler(w http.ResponseWriter, r *http.Request) {
+	namespaces := []NamespaceStatus{}
+	for _, ns := range a.Manager.GetNamespaces() {
+		if auth.AllowedNamespace(r.Context(), ns.Name) {
+			namespaces = append(namespaces, ns)
+		}
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, namespaces)
+}
+
+func (a *Api) GetNamespaceHandler(w http.ResponseWriter, r *http.Request) {
+	name, err := httputil.GetURLParam(r, "namespace")
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !auth.AllowedNamespace(r.Context(), name) {
+		httputil.WriteError(w, http.StatusForbidden, fmt.Sprintf("Not allowed in namespace: %s", name))
+		return
+	}
+
+	ns, err := a.Manager.GetNamespace(name)
+	if err != nil {
+		httputil.WriteError(w, http.StatusNotFound, fmt.Sprintf("No namespace found with name: %s", name))
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, ns)
+}
+
+func (a *Api) UpdateQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := a.namespaceParam(w, r)
+	if !ok {
+		return
+	}
+
+	quota, err := httputil.DecodeJSON[Quota](r)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("%v", err))
+		return
+	}
+
+	if _, err := a.Manager.UpdateQuota(name, quota); err != nil {
+		httputil.WriteError(w, http.StatusNotFound, fmt.Sprintf("No namespace found with name: %s", name))
+		return
+	}
+
+	ns, _ := a.Manager.GetNamespace(name)
+	handlerLog.WithField("namespace", name).Info("Namespace quota updated via API")
+	httputil.WriteJSON(w, http.StatusOK, ns)
+}
+
+func (a *Api) DeleteNamespaceHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := a.namespaceParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := a.Manager.DeleteNamespace(name)
+	switch {
+	case errors.Is(err, ErrNamespaceNotFound):
+		httputil.WriteError(w, http.StatusNotFound, fmt.Sprintf("No namespace found with name: %s", name))
+		return
+	case errors.Is(err, ErrNamespaceInUse):
+		httputil.WriteError(w, http.StatusConflict, fmt.Sprintf("Namespace %s still has active tasks", name))
+		return
+	case errors.Is(err, ErrNamespaceHasVolumes):
+		httputil.WriteError(w, http.StatusConflict, fmt.Sprintf("Namespace %s still has volumes", name))
+		return
+	case err != nil:
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	handlerLog.WithField("namespace", name).Info("Namespace deleted via API")
+	httputil.WriteNoContent(w)
+}
+
+// namespaceParam reads the namespace from the URL and checks that it exists
+// and that the caller may use it.
+func (a *Api) namespaceParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name, err := httputil.GetURLParam(r, "namespace")
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return "", false
+	}
+
+	if !auth.AllowedNamespace(r.Context(), name) {
+		httputil.WriteError(w, http.StatusForbidden, fmt.Sprintf("Not allowed in namespace: %s", name))
+		return "", false
+	}
+
+	if _, err := a.Manager.GetNamespace(name); err != nil {
+		httputil.WriteError(w, http.StatusNotFound, fmt.Sprintf("No namespace found with name: %s", name))
+		return "", false
+	}
+
+	return name, true
+}
@@ -0,0 +1,75 @@
+package manager
+
+import (
+	"Mine-Cube/task"
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidNetwork = errors.New("invalid network")
+
+// validateNetworks checks a task's service name and the networks it joins.
+// Both become DNS names, and network names are also part of Docker network
+// names, so they follow the rules for namespace names. Networks are created
+// by the workers as tasks join them, one per namespace and name. They are
+// bridge networks, local to a worker, so tasks sharing a network are
+// scheduled to the same worker.
+func validateNetworks(t *task.Task) error {
+	if t.Service != "" {
+		if err := ValidateNamespaceName(t.Service); err != nil {
+			return specFieldError("Service", fmt.Errorf("%w: %v", ErrInvalidNetwork, err))
+		}
+	}
+
+	seen := make(map[string]bool, len(t.Networks))
+	for i, name := range t.Networks {
+		field := fmt.Sprintf("Networks[%d]", i)
+		if err := ValidateNamespaceName(name); err != nil {
+			return specFieldError(field, fmt.Errorf("%w: %v", ErrInvalidNetwork, err))
+		}
+		if seen[name] {
+			return specFieldError(field, fmt.Errorf("%w: network %q is joined more than once", ErrInvalidNetwork, name))
+		}
+		seen[name] = true
+	}
+
+	return nil
+}
+
+// networkWorker returns the worker where the other active tasks sharing a
+// network with t run, or worker when there are none. It is an error for
+// them to be on another worker than worker, if that is set, as bridge
+// networks do not reach across workers.
+func (m *Manager) networkWorker(t *task.Task, worker string) (string, error) {
+	if len(t.Networks) == 0 {
+		return worker, nil
+	}
+
+	joins := make(map[string]bool, len(t.Networks))
+	for _, name := range t.Networks {
+		joins[name] = true
+	}
+
+	for _, other := range m.TaskDb {
+		if other.ID == t.ID || namespaceOf(other) != namespaceOf(t) || !isActive(other) {
+			continue
+		}
+
+		otherWorker, ok := m.TaskWorkerMap[other.ID]
+		if !ok {
+			continue
+		}
+
+		for _, name := range other.Networks {
+			if !joins[name] {
+				continue
+			}
+			if worker != "" && otherWorker != worker {
+				return "", fmt.Errorf("%w: network %q has tasks on worker %s, not %s", ErrInvalidNetwork, name, otherWorker, worker)
+			}
+			worker = otherWorker
+		}
+	}
+
+	return worker, nil
+}
@@ -0,0 +1,33 @@
+package manager
+
+import (
+	httputil "Mine-Cube/utils/http"
+	"fmt"
+	"net/http"
+	nethttputil "net/http/httputil"
+	"net/url"
+)
+
+// proxyToWorker forwards the request as-is to the same path on the given
+// worker. Responses are flushed as they arrive, so streams such as followed
+// logs pass through without being buffered.
+// The caller's credentials are swapped for the manager's service credential.
+func (m *Manager) proxyToWorker(w http.ResponseWriter, r *http.Request, worker string) {
+	target := &url.URL{Scheme: m.scheme, Host: worker}
+
+	proxy := &nethttputil.ReverseProxy{
+		Rewrite: func(pr *nethttputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.SetXForwarded()
+			m.authorizeWorkerRequest(pr.Out)
+		},
+		Transport:     m.client.Transport,
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.WithField("worker", worker).Warnf("Error proxying request to worker: %v", err)
+			httputil.WriteError(w, http.StatusBadGateway, fmt.Sprintf("Error connecting to worker %s: %v", worker, err))
+		},
+	}
+
+	proxy.ServeHTTP(w, r)
+}
@@ -0,0 +1,319 @@
+package manager
+
+import (
+	"Mine-Cube/task"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var MAX_QUERY_LIMIT = 1000
+
+// TaskQuery selects, orders and pages the tasks returned by GET /tasks.
+// Zero values leave the corresponding filter out.
+type TaskQuery struct {
+	Namespaces []string
+	States     []task.State
+	Workers    []string
+	Images     []string
+	NamePrefix string
+	Selector   task.Selector
+
+	StartedAfter   time.Time
+	StartedBefore  time.Time
+	FinishedAfter  time.Time
+	FinishedBefore time.Time
+
+	// SortBy is one of id, name, state, image, start_time or finish_time.
+	// States sort by name, as they are shown.
+	SortBy string
+	Desc   bool
+
+	// Limit is the page size; 0 returns every matching task
+	Limit  int
+	Cursor *taskCursor
+}
+
+// taskCursor marks the position after the last task of a page. It holds the
+// sort key rather than an offset, so pages stay consistent while tasks are
+// added.
+type taskCursor struct {
+	SortBy     string
+	Desc       bool
+	ID         uuid.UUID
+	Name       string
+	Image      string
+	State      task.State
+	StartTime  time.Time
+	FinishTime time.Time
+}
+
+func newTaskCursor(q TaskQuery, t *task.Task) taskCursor {
+	return taskCursor{
+		SortBy:     q.SortBy,
+		Desc:       q.Desc,
+		ID:         t.ID,
+		Name:       t.Name,
+		Image:      t.Image,
+		State:      t.State,
+		StartTime:  t.StartTime,
+		FinishTime: t.FinishTime,
+	}
+}
+
+func (c taskCursor) task() *task.Task {
+	return &task.Task{
+		ID:         c.ID,
+		Name:       c.Name,
+		Image:      c.Image,
+		State:      c.State,
+		StartTime:  c.StartTime,
+		FinishTime: c.FinishTime,
+	}
+}
+
+func (c taskCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeTaskCursor(s string) (*taskCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	var c taskCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	return &c, nil
+}
+
+func parseQueryTime(values url.Values, key string) (time.Time, error) {
+	v := values.Get(key)
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time for %s, expected RFC3339: %q", key, v)
+	}
+
+	return t, nil
+}
+
+func splitQuery(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			if part != "" {
+				out = append(out, part)
+			}
+		}
+	}
+	return out
+}
+
+// ParseTaskQuery reads a TaskQuery from URL query parameters. List filters
+// accept repeated or comma separated values, and sort takes a field name
+// prefixed with "-" for descending order.
+func ParseTaskQuery(values url.Values) (TaskQuery, error) {
+	q := TaskQuery{
+		Namespaces: splitQuery(values["namespace"]),
+		Workers:    splitQuery(values["worker"]),
+		Images:     splitQuery(values["image"]),
+		NamePrefix: values.Get("name_prefix"),
+		SortBy:     "start_time",
+	}
+
+	for _, v := range splitQuery(values["state"]) {
+		s, err := task.ParseState(v)
+		if err != nil {
+			return q, err
+		}
+		q.States = append(q.States, s)
+	}
+
+	// label is a shorthand for selector, and both may be repeated
+	for _, v := range append(values["selector"], values["label"]...) {
+		sel, err := task.ParseSelector(v)
+		if err != nil {
+			return q, err
+		}
+		q.Selector = append(q.Selector, sel...)
+	}
+
+	var err error
+	if q.StartedAfter, err = parseQueryTime(values, "started_after"); err != nil {
+		return q, err
+	}
+	if q.StartedBefore, err = parseQueryTime(values, "started_before"); err != nil {
+		return q, err
+	}
+	if q.FinishedAfter, err = parseQueryTime(values, "finished_after"); err != nil {
+		return q, err
+	}
+	if q.FinishedBefore, err = parseQueryTime(values, "finished_before"); err != nil {
+		return q, err
+	}
+
+	if s := values.Get("sort"); s != "" {
+		q.Desc = strings.HasPrefix(s, "-")
+		q.SortBy = strings.TrimPrefix(s, "-")
+	}
+
+	switch q.SortBy {
+	case "id", "name", "state", "image", "start_time", "finish_time":
+	default:
+		return q, fmt.Errorf("invalid sort field: %q", q.SortBy)
+	}
+
+	if l := values.Get("limit"); l != "" {
+		q.Limit, err = strconv.Atoi(l)
+		if err != nil || q.Limit < 1 || q.Limit > MAX_QUERY_LIMIT {
+			return q, fmt.Errorf("limit must be between 1 and %d", MAX_QUERY_LIMIT)
+		}
+	}
+
+	if c := values.Get("cursor"); c != "" {
+		q.Cursor, err = decodeTaskCursor(c)
+		if err != nil {
+			return q, err
+		}
+		if q.Cursor.SortBy != q.SortBy || q.Cursor.Desc != q.Desc {
+			return q, fmt.Errorf("cursor does not match the requested sort order")
+		}
+	}
+
+	return q, nil
+}
+
+func containsString(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Manager) matchesQuery(q TaskQuery, t *task.Task) bool {
+	if len(q.Namespaces) > 0 && !containsString(q.Namespaces, namespaceOf(t)) {
+		return false
+	}
+	if len(q.States) > 0 && !task.Contains(q.States, t.State) {
+		return false
+	}
+	if len(q.Workers) > 0 && !containsString(q.Workers, m.TaskWorkerMap[t.ID]) {
+		return false
+	}
+	if len(q.Images) > 0 && !containsString(q.Images, t.Image) {
+		return false
+	}
+	if q.NamePrefix != "" && !strings.HasPrefix(t.Name, q.NamePrefix) {
+		return false
+	}
+	if !q.Selector.Matches(t.Labels) {
+		return false
+	}
+
+	if !q.StartedAfter.IsZero() && !t.StartTime.After(q.StartedAfter) {
+		return false
+	}
+	if !q.StartedBefore.IsZero() && (t.StartTime.IsZero() || !t.StartTime.Before(q.StartedBefore)) {
+		return false
+	}
+	if !q.FinishedAfter.IsZero() && !t.FinishTime.After(q.FinishedAfter) {
+		return false
+	}
+	if !q.FinishedBefore.IsZero() && (t.FinishTime.IsZero() || !t.FinishTime.Before(q.FinishedBefore)) {
+		return false
+	}
+
+	return true
+}
+
+// compareTasks orders two tasks by the query's sort field, falling back to
+// the task ID so that the order is total.
+func compareTasks(sortBy string, a *task.Task, b *task.Task) int {
+	c := 0
+
+	switch sortBy {
+	case "name":
+		c = strings.Compare(a.Name, b.Name)
+	case "state":
+		c = strings.Compare(a.State.String(), b.State.String())
+	case "image":
+		c = strings.Compare(a.Image, b.Image)
+	case "start_time":
+		c = a.StartTime.Compare(b.StartTime)
+	case "finish_time":
+		c = a.FinishTime.Compare(b.FinishTime)
+	}
+
+	if c != 0 {
+		return c
+	}
+	return strings.Compare(a.ID.String(), b.ID.String())
+}
+
+// QueryTasks returns one page of the tasks matching q, the cursor of the
+// next page ("" on the last page) and the number of matching tasks.
+func (m *Manager) QueryTasks(q TaskQuery) ([]task.Task, string, int) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	matched := []*task.Task{}
+	for _, t := range m.TaskDb {
+		if m.matchesQuery(q, t) {
+			matched = append(matched, t)
+		}
+	}
+
+	less := func(a *task.Task, b *task.Task) bool {
+		c := compareTasks(q.SortBy, a, b)
+		if q.Desc {
+			return c > 0
+		}
+		return c < 0
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return less(matched[i], matched[j])
+	})
+
+	total := len(matched)
+
+	page := matched
+	if q.Cursor != nil {
+		last := q.Cursor.task()
+		start := sort.Search(len(page), func(i int) bool {
+			return less(last, page[i])
+		})
+		page = page[start:]
+	}
+
+	next := ""
+	if q.Limit > 0 && len(page) > q.Limit {
+		page = page[:q.Limit]
+		next = newTaskCursor(q, page[len(page)-1]).encode()
+	}
+
+	// copied, as the tasks go on changing once the lock is released
+	tasks := make([]task.Task, 0, len(page))
+	for _, t := range page {
+		tasks = append(tasks, *t)
+	}
+
+	return tasks, next, total
+}
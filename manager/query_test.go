@@ -0,0 +1,60 @@
+package manager
+
+import (
+	"Mine-Cube/task"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestQueryTasksSortsStatesByName(t *testing.T) {
+	m := NewManager([]string{})
+	for _, state := range []task.State{task.Pending, task.Running, task.Completed, task.Failed, task.Paused} {
+		id := uuid.New()
+		m.TaskDb[id] = &task.Task{ID: id, Name: state.String(), State: state}
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{query: "sort=state", want: []string{"completed", "failed", "paused", "pending", "running"}},
+		{query: "sort=-state", want: []string{"running", "pending", "paused", "failed", "completed"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// one task per page, so that every page starts from a cursor
+			values.Set("limit", "1")
+
+			var got []string
+			for {
+				q, err := ParseTaskQuery(values)
+				if err != nil {
+					t.Fatalf("ParseTaskQuery(): %v", err)
+				}
+
+				tasks, next, _ := m.QueryTasks(q)
+				for _, tk := range tasks {
+					got = append(got, tk.State.String())
+				}
+
+				if next == "" {
+					break
+				}
+				values.Set("cursor", next)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package manager
+
+import (
+	"Mine-Cube/pki"
+	httputil "Mine-Cube/utils/http"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+func (a *Api) RegisterWorkerHandler(w http.ResponseWriter, r *http.Request) {
+	req, err := httputil.DecodeJSON[pki.RegistrationRequest](r)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("%v", err))
+		return
+	}
+
+	resp, err := a.Manager.RegisterWorker(req)
+	switch {
+	case errors.Is(err, ErrWorkerHostNotAllowed):
+		httputil.WriteError(w, http.StatusForbidden, err.Error())
+		return
+	case err != nil:
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	handlerLog.WithFields(map[string]interface{}{
+		"worker": req.Address,
+		"name":   req.Name,
+	}).Info("Worker certificate issued via API")
+	httputil.WriteJSON(w, http.StatusCreated, resp)
+}
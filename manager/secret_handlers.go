@@ -0,0 +1,150 @@
+package manager
+
+import (
+	httputil "Mine-Cube/utils/http"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// secretRequest carries a secret's value in. Responses only ever carry a
+// Secret, which has no value.
+type secretRequest struct {
+	Name  string
+	Value string
+}
+
+// secretNamespace is namespaceParam for the secrets API, which is only
+// available with a master key.
+func (a *Api) secretNamespace(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if a.Manager.Secrets == nil {
+		httputil.WriteError(w, http.StatusServiceUnavailable, ErrSecretsDisabled.Error())
+		return "", false
+	}
+
+	return a.namespaceParam(w, r)
+}
+
+func writeSecretError(w http.ResponseWriter, err error, namespace string, name string) {
+	switch {
+	case errors.Is(err, ErrSecretNotFound):
+		httputil.WriteError(w, http.StatusNotFound, fmt.Sprintf("No secret found with name: %s/%s", namespace, name))
+	case errors.Is(err, ErrSecretExists):
+		httputil.WriteError(w, http.StatusConflict, fmt.Sprintf("Secret already exists: %s/%s", namespace, name))
+	default:
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+	}
+}
+
+func (a *Api) CreateSecretHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, ok := a.secretNamespace(w, r)
+	if !ok {
+		return
+	}
+
+	req, err := httputil.DecodeJSON[secretRequest](r)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("%v", err))
+		return
+	}
+
+	secret, err := a.Manager.Secrets.Put(namespace, req.Name, []byte(req.Value), false)
+	if err != nil {
+		writeSecretError(w, err, namespace, req.Name)
+		return
+	}
+
+	handlerLog.WithFields(map[string]interface{}{
+		"namespace": namespace,
+		"secret":    secret.Name,
+	}).Info("Secret created via API")
+	httputil.WriteJSON(w, http.StatusCreated, secret)
+}
+
+func (a *Api) GetSecretsHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, ok := a.secretNamespace(w, r)
+	if !ok {
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, a.Manager.Secrets.List(namespace))
+}
+
+func (a *Api) GetSecretHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, ok := a.secretNamespace(w, r)
+	if !ok {
+		return
+	}
+
+	name, err := httputil.GetURLParam(r, "secret")
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	secret, err := a.Manager.Secrets.Get(namespace, name)
+	if err != nil {
+		writeSecretError(w, err, namespace, name)
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, secret)
+}
+
+// UpdateSecretHandler replaces a secret's value. Running tasks keep the
+// value they were started with.
+func (a *Api) UpdateSecretHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, ok := a.secretNamespace(w, r)
+	if !ok {
+		return
+	}
+
+	name, err := httputil.GetURLParam(r, "secret")
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	req, err := httputil.DecodeJSON[secretRequest](r)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("%v", err))
+		return
+	}
+
+	secret, err := a.Manager.Secrets.Put(namespace, name, []byte(req.Value), true)
+	if err != nil {
+		writeSecretError(w, err, namespace, name)
+		return
+	}
+
+	handlerLog.WithFields(map[string]interface{}{
+		"namespace": namespace,
+		"secret":    name,
+		"version":   secret.Version,
+	}).Info("Secret updated via API")
+	httputil.WriteJSON(w, http.StatusOK, secret)
+}
+
+func (a *Api) DeleteSecretHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, ok := a.secretNamespace(w, r)
+	if !ok {
+		return
+	}
+
+	name, err := httputil.GetURLParam(r, "secret")
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := a.Manager.Secrets.Delete(namespace, name); err != nil {
+		writeSecretError(w, err, namespace, name)
+		return
+	}
+
+	handlerLog.WithFields(map[string]interface{}{
+		"namespace": namespace,
+		"secret":    name,
+	}).Info("Secret deleted via API")
+	httputil.WriteNoContent(w)
+}
@@ -0,0 +1,379 @@
+package manager
+
+import (
+	"Mine-Cube/task"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var MAX_SECRET_SIZE = 512 * 1024
+
+var secretNamePattern = regexp.MustCompile(`^[a-zA-Z0-9]([-._a-zA-Z0-9]*[a-zA-Z0-9])?$`)
+
+var ErrSecretNotFound = errors.New("secret not found")
+var ErrSecretExists = errors.New("secret already exists")
+var ErrSecretsDisabled = errors.New("secrets are disabled: no master key configured")
+var ErrInvalidSecretRef = errors.New("invalid secret reference")
+var ErrSecretsNeedTLS = errors.New("secrets are only sent to workers over TLS")
+
+// Secret describes a stored secret. Its value is never part of it.
+type Secret struct {
+	Name      string
+	Namespace string
+	// Version increases every time the value is replaced
+	Version   int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// sealedSecret is a secret as kept in memory and on disk: the value is
+// encrypted with the master key, with the secret's namespace and name as
+// additional data so that sealed values cannot be swapped between secrets.
+type sealedSecret struct {
+	Secret
+	Data []byte
+}
+
+// SecretStore keeps secrets encrypted with AES-256-GCM under a master key.
+// When Path is set the sealed secrets are also written there, and read back
+// on startup.
+type SecretStore struct {
+	Path string
+
+	mu      sync.Mutex
+	aead    cipher.AEAD
+	secrets map[string]*sealedSecret
+}
+
+// ValidateSecretName only allows names that are safe to use as file names.
+func ValidateSecretName(name string) error {
+	if len(name) > 253 || !secretNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid secret name %q: must be alphanumeric, '-', '.' or '_', at most 253 characters", name)
+	}
+	return nil
+}
+
+// ParseMasterKey accepts a 32 byte key encoded as base64 or hex.
+func ParseMasterKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := hex.DecodeString(s); err == nil && len(key) == 32 {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("master key must be 32 bytes, encoded as base64 or hex")
+}
+
+func NewSecretStore(key []byte, path string) (*SecretStore, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("error creating cipher: %w", err)
+	}
+
+	s := &SecretStore{
+		Path:    path,
+		aead:    aead,
+		secrets: make(map[string]*sealedSecret),
+	}
+
+	if path != "" {
+		if err := s.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+func secretKey(namespace string, name string) string {
+	return namespace + "/" + name
+}
+
+func (s *SecretStore) load() error {
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading secrets: %w", err)
+	}
+
+	var sealed []*sealedSecret
+	if err := json.Unmarshal(data, &sealed); err != nil {
+		return fmt.Errorf("error parsing secrets: %w", err)
+	}
+
+	for _, ss := range sealed {
+		// make sure the master key is the one the secrets were sealed with
+		if _, err := s.open(ss); err != nil {
+			return fmt.Errorf("error decrypting secret %s/%s, wrong master key?", ss.Namespace, ss.Name)
+		}
+		s.secrets[secretKey(ss.Namespace, ss.Name)] = ss
+	}
+
+	log.WithField("count", len(sealed)).Info("Secrets loaded")
+
+	return nil
+}
+
+// save writes every sealed secret to Path, replacing the file atomically.
+func (s *SecretStore) save() error {
+	if s.Path == "" {
+		return nil
+	}
+
+	sealed := make([]*sealedSecret, 0, len(s.secrets))
+	for _, ss := range s.secrets {
+		sealed = append(sealed, ss)
+	}
+
+	data, err := json.Marshal(sealed)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0700); err != nil {
+		return fmt.Errorf("error creating secrets directory: %w", err)
+	}
+
+	tmp := s.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("error writing secrets: %w", err)
+	}
+
+	return os.Rename(tmp, s.Path)
+}
+
+func (s *SecretStore) seal(namespace string, name string, value []byte) ([]byte, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return s.aead.Seal(nonce, nonce, value, []byte(secretKey(namespace, name))), nil
+}
+
+func (s *SecretStore) open(ss *sealedSecret) ([]byte, error) {
+	n := s.aead.NonceSize()
+	if len(ss.Data) < n {
+		return nil, fmt.Errorf("sealed secret is too short")
+	}
+
+	return s.aead.Open(nil, ss.Data[:n], ss.Data[n:], []byte(secretKey(ss.Namespace, ss.Name)))
+}
+
+// Put creates a secret, or replaces the value of an existing one when
+// replace is set.
+func (s *SecretStore) Put(namespace string, name string, value []byte, replace bool) (Secret, error) {
+	if err := ValidateSecretName(name); err != nil {
+		return Secret{}, err
+	}
+	if len(value) > MAX_SECRET_SIZE {
+		return Secret{}, fmt.Errorf("secret value is larger than %d bytes", MAX_SECRET_SIZE)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := secretKey(namespace, name)
+	existing, exists := s.secrets[key]
+
+	if exists && !replace {
+		return Secret{}, ErrSecretExists
+	}
+	if !exists && replace {
+		return Secret{}, ErrSecretNotFound
+	}
+
+	data, err := s.seal(namespace, name, value)
+	if err != nil {
+		return Secret{}, fmt.Errorf("error encrypting secret: %w", err)
+	}
+
+	now := time.Now().UTC()
+	ss := &sealedSecret{
+		Secret: Secret{Name: name, Namespace: namespace, Version: 1, CreatedAt: now, UpdatedAt: now},
+		Data:   data,
+	}
+	if exists {
+		ss.Version = existing.Version + 1
+		ss.CreatedAt = existing.CreatedAt
+	}
+
+	s.secrets[key] = ss
+	if err := s.save(); err != nil {
+		if exists {
+			s.secrets[key] = existing
+		} else {
+			delete(s.secrets, key)
+		}
+		return Secret{}, err
+	}
+
+	return ss.Secret, nil
+}
+
+func (s *SecretStore) Get(namespace string, name string) (Secret, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ss, ok := s.secrets[secretKey(namespace, name)]
+	if !ok {
+		return Secret{}, ErrSecretNotFound
+	}
+	return ss.Secret, nil
+}
+
+// List returns the secrets of a namespace, or of every namespace when it is
+// empty, ordered by namespace and name.
+func (s *SecretStore) List(namespace string) []Secret {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	secrets := []Secret{}
+	for _, ss := range s.secrets {
+		if namespace == "" || ss.Namespace == namespace {
+			secrets = append(secrets, ss.Secret)
+		}
+	}
+
+	sort.Slice(secrets, func(i, j int) bool {
+		return secretKey(secrets[i].Namespace, secrets[i].Name) < secretKey(secrets[j].Namespace, secrets[j].Name)
+	})
+
+	return secrets
+}
+
+func (s *SecretStore) Delete(namespace string, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := secretKey(namespace, name)
+	ss, ok := s.secrets[key]
+	if !ok {
+		return ErrSecretNotFound
+	}
+
+	delete(s.secrets, key)
+	if err := s.save(); err != nil {
+		s.secrets[key] = ss
+		return err
+	}
+
+	return nil
+}
+
+// Value decrypts a secret. Only the manager uses it, to hand secrets to the
+// worker starting a task.
+func (s *SecretStore) Value(namespace string, name string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ss, ok := s.secrets[secretKey(namespace, name)]
+	if !ok {
+		return nil, ErrSecretNotFound
+	}
+
+	value, err := s.open(ss)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting secret %s/%s: %w", namespace, name, err)
+	}
+	return value, nil
+}
+
+// validateSecretRefs checks that every secret a task refers to exists in
+// its namespace and says where to put it.
+func (m *Manager) validateSecretRefs(t *task.Task) error {
+	if len(t.Secrets) == 0 {
+		return nil
+	}
+	if m.Secrets == nil {
+		return specFieldError("Secrets", ErrSecretsDisabled)
+	}
+	if m.scheme != "https" {
+		return specFieldError("Secrets", ErrSecretsNeedTLS)
+	}
+
+	for i, ref := range t.Secrets {
+		field := fmt.Sprintf("Secrets[%d]", i)
+		if ref.Env == "" && ref.File == "" {
+			return specFieldError(field, fmt.Errorf("%w: secret %q must set Env, File or both", ErrInvalidSecretRef, ref.Name))
+		}
+		if ref.File != "" && !filepath.IsAbs(ref.File) {
+			return specFieldError(field, fmt.Errorf("%w: secret %q File must be an absolute path", ErrInvalidSecretRef, ref.Name))
+		}
+		if _, err := m.Secrets.Get(namespaceOf(t), ref.Name); err != nil {
+			return specFieldError(field, fmt.Errorf("%w: %s/%s", err, namespaceOf(t), ref.Name))
+		}
+	}
+
+	return nil
+}
+
+// secretValues decrypts the secrets a task refers to.
+func (m *Manager) secretValues(t *task.Task) (map[string][]byte, error) {
+	if len(t.Secrets) == 0 {
+		return nil, nil
+	}
+	if m.Secrets == nil {
+		return nil, ErrSecretsDisabled
+	}
+	// secret values must never cross the network in the clear
+	if m.scheme != "https" {
+		return nil, ErrSecretsNeedTLS
+	}
+
+	values := make(map[string][]byte, len(t.Secrets))
+	for _, ref := range t.Secrets {
+		v, err := m.Secrets.Value(namespaceOf(t), ref.Name)
+		if err != nil {
+			return nil, err
+		}
+		values[ref.Name] = v
+	}
+
+	return values, nil
+}
+
+// encodeWork marshals a task event for a worker, adding the contents of the
+// task's secrets and configs when the event starts the task.
+func (m *Manager) encodeWork(te task.TaskEvent) ([]byte, error) {
+	req := task.WorkRequest{TaskEvent: te}
+
+	if te.State != task.Completed {
+		secrets, err := m.secretValues(&te.Task)
+		if err != nil {
+			return nil, err
+		}
+		req.Secrets = secrets
+
+		configs, err := m.configValues(&te.Task)
+		if err != nil {
+			return nil, err
+		}
+		req.Configs = configs
+	}
+
+	return json.Marshal(req)
+}
@@ -0,0 +1,296 @@
+package manager
+
+import (
+	"Mine-Cube/ingress"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+)
+
+var ErrServiceNotFound = errors.New("service not found")
+var ErrServiceExists = errors.New("service already exists")
+var ErrInvalidService = errors.New("invalid service")
+var ErrListenPortInUse = errors.New("listen port is used by another service")
+
+// ServiceRoute sends HTTP requests for Host, or any host when empty, whose
+// path starts with PathPrefix to the service's tasks.
+type ServiceRoute struct {
+	Host       string
+	PathPrefix string
+	// Port is the container port the route leads to, such as "80/tcp"
+	Port string
+}
+
+// ServiceTCPRoute forwards connections to the ingress on ListenPort to the
+// service's tasks.
+type ServiceTCPRoute struct {
+	ListenPort int
+	Port       string
+}
+
+// Service declares how the ingress reaches the tasks whose Service field
+// names it. Tasks of a service without a spec are still discoverable over
+// DNS; they just have no routes.
+type Service struct {
+	Name      string
+	Namespace string
+	// LoadBalancer is round_robin, the default, or least_connections
+	LoadBalancer ingress.Balancer
+	// Retries is how many other tasks a request or connection is tried on
+	// when it cannot be delivered
+	Retries   int
+	Routes    []ServiceRoute
+	TCPRoutes []ServiceTCPRoute
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func serviceKey(namespace string, name string) string {
+	return namespace + "/" + name
+}
+
+// routePort parses a route's container port, which defaults to TCP.
+func routePort(port string) (nat.Port, error) {
+	proto, p := nat.SplitProtoPort(port)
+	if proto != "tcp" {
+		return "", fmt.Errorf("%w: port %q must be a TCP port", ErrInvalidService, port)
+	}
+	if _, err := nat.ParsePort(p); err != nil || p == "" {
+		return "", fmt.Errorf("%w: invalid port %q", ErrInvalidService, port)
+	}
+	return nat.Port(p + "/tcp"), nil
+}
+
+// validateService checks a service spec and fills in its defaults. The
+// caller holds m.mu, so that no other service takes its listen ports before
+// it is stored.
+func (m *Manager) validateService(s *Service) error {
+	if err := ValidateNamespaceName(s.Name); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidService, err)
+	}
+
+	switch s.LoadBalancer {
+	case "":
+		s.LoadBalancer = ingress.RoundRobin
+	case ingress.RoundRobin, ingress.LeastConnections:
+	default:
+		return fmt.Errorf("%w: unknown load balancer %q", ErrInvalidService, s.LoadBalancer)
+	}
+
+	if s.Retries < 0 {
+		return fmt.Errorf("%w: Retries must not be negative", ErrInvalidService)
+	}
+
+	// copied, as the caller's slices may be reused
+	s.Routes = append([]ServiceRoute(nil), s.Routes...)
+	for i, r := range s.Routes {
+		port, err := routePort(r.Port)
+		if err != nil {
+			return err
+		}
+		s.Routes[i].Port = string(port)
+		s.Routes[i].Host = strings.ToLower(r.Host)
+
+		if r.PathPrefix == "" {
+			s.Routes[i].PathPrefix = "/"
+		} else if !strings.HasPrefix(r.PathPrefix, "/") {
+			return fmt.Errorf("%w: path prefix %q must start with /", ErrInvalidService, r.PathPrefix)
+		}
+	}
+
+	s.TCPRoutes = append([]ServiceTCPRoute(nil), s.TCPRoutes...)
+	listenPorts := make(map[int]bool, len(s.TCPRoutes))
+	for i, r := range s.TCPRoutes {
+		port, err := routePort(r.Port)
+		if err != nil {
+			return err
+		}
+		s.TCPRoutes[i].Port = string(port)
+
+		if r.ListenPort < 1 || r.ListenPort > 65535 {
+			return fmt.Errorf("%w: invalid listen port %d", ErrInvalidService, r.ListenPort)
+		}
+		if listenPorts[r.ListenPort] {
+			return fmt.Errorf("%w: listen port %d is used more than once", ErrInvalidService, r.ListenPort)
+		}
+		listenPorts[r.ListenPort] = true
+
+		// the ingress listens on TCP ports for all namespaces
+		for _, other := range m.ServiceDb {
+			if other.Namespace == s.Namespace && other.Name == s.Name {
+				continue
+			}
+			for _, o := range other.TCPRoutes {
+				if o.ListenPort == r.ListenPort {
+					return fmt.Errorf("%w: %d", ErrListenPortInUse, r.ListenPort)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *Manager) CreateService(s Service) (*Service, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.validateService(&s); err != nil {
+		return nil, err
+	}
+
+	key := serviceKey(s.Namespace, s.Name)
+	if _, ok := m.ServiceDb[key]; ok {
+		return nil, ErrServiceExists
+	}
+
+	s.CreatedAt = time.Now().UTC()
+	s.UpdatedAt = s.CreatedAt
+	m.ServiceDb[key] = &s
+
+	log.WithFields(map[string]interface{}{
+		"namespace": s.Namespace,
+		"service":   s.Name,
+	}).Info("Service created")
+
+	return &s, nil
+}
+
+// UpdateService replaces a service's spec. The ingress picks up the new
+// routes on its next refresh.
+func (m *Manager) UpdateService(s Service) (*Service, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.ServiceDb[serviceKey(s.Namespace, s.Name)]
+	if !ok {
+		return nil, ErrServiceNotFound
+	}
+
+	if err := m.validateService(&s); err != nil {
+		return nil, err
+	}
+
+	s.CreatedAt = existing.CreatedAt
+	s.UpdatedAt = time.Now().UTC()
+	m.ServiceDb[serviceKey(s.Namespace, s.Name)] = &s
+
+	log.WithFields(map[string]interface{}{
+		"namespace": s.Namespace,
+		"service":   s.Name,
+	}).Info("Service updated")
+
+	return &s, nil
+}
+
+func (m *Manager) GetService(namespace string, name string) (*Service, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s, ok := m.ServiceDb[serviceKey(namespace, name)]
+	if !ok {
+		return nil, ErrServiceNotFound
+	}
+	return s, nil
+}
+
+func (m *Manager) GetServices(namespace string) []*Service {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	services := []*Service{}
+	for _, s := range m.ServiceDb {
+		if s.Namespace == namespace {
+			services = append(services, s)
+		}
+	}
+
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Name < services[j].Name
+	})
+
+	return services
+}
+
+// DeleteService removes a service's routes. Its tasks keep running.
+func (m *Manager) DeleteService(namespace string, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := serviceKey(namespace, name)
+	if _, ok := m.ServiceDb[key]; !ok {
+		return ErrServiceNotFound
+	}
+
+	delete(m.ServiceDb, key)
+
+	log.WithFields(map[string]interface{}{
+		"namespace": namespace,
+		"service":   name,
+	}).Info("Service deleted")
+
+	return nil
+}
+
+// IngressTable returns the routes of every service, each leading to the
+// published ports of the service's serving tasks.
+func (m *Manager) IngressTable() ingress.Table {
+	return m.ingressTable(func(string) bool { return true })
+}
+
+// ingressTable returns the routes of the services in the namespaces for
+// which include returns true.
+func (m *Manager) ingressTable(include func(namespace string) bool) ingress.Table {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	table := ingress.Table{}
+
+	for _, s := range m.ServiceDb {
+		if !include(s.Namespace) {
+			continue
+		}
+
+		endpoints := m.Endpoints(s.Namespace, s.Name)
+
+		backends := func(port string) []string {
+			var addresses []string
+			for _, e := range endpoints {
+				if string(e.Port) == port {
+					addresses = append(addresses, fmt.Sprintf("%s:%d", e.Host, e.HostPort))
+				}
+			}
+			return addresses
+		}
+		routeName := func(port string) string {
+			return fmt.Sprintf("%s/%s:%s", s.Namespace, s.Name, port)
+		}
+
+		for _, r := range s.Routes {
+			table.HTTP = append(table.HTTP, ingress.Route{
+				Name:       routeName(r.Port),
+				Host:       r.Host,
+				PathPrefix: r.PathPrefix,
+				Balancer:   s.LoadBalancer,
+				Retries:    s.Retries,
+				Backends:   backends(r.Port),
+			})
+		}
+
+		for _, r := range s.TCPRoutes {
+			table.TCP = append(table.TCP, ingress.TCPRoute{
+				Name:       routeName(r.Port),
+				ListenPort: r.ListenPort,
+				Balancer:   s.LoadBalancer,
+				Retries:    s.Retries,
+				Backends:   backends(r.Port),
+			})
+		}
+	}
+
+	return table
+}
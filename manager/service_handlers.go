@@ -0,0 +1,146 @@
+package manager
+
+import (
+	"Mine-Cube/auth"
+	httputil "Mine-Cube/utils/http"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+func writeServiceError(w http.ResponseWriter, err error, namespace string, name string) {
+	switch {
+	case errors.Is(err, ErrServiceNotFound):
+		httputil.WriteError(w, http.StatusNotFound, fmt.Sprintf("No service found with name: %s/%s", namespace, name))
+	case errors.Is(err, ErrServiceExists):
+		httputil.WriteError(w, http.StatusConflict, fmt.Sprintf("Service already exists: %s/%s", namespace, name))
+	case errors.Is(err, ErrListenPortInUse):
+		httputil.WriteError(w, http.StatusConflict, err.Error())
+	default:
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+	}
+}
+
+func (a *Api) CreateServiceHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, ok := a.namespaceParam(w, r)
+	if !ok {
+		return
+	}
+
+	s, err := httputil.DecodeJSON[Service](r)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("%v", err))
+		return
+	}
+	s.Namespace = namespace
+
+	created, err := a.Manager.CreateService(s)
+	if err != nil {
+		writeServiceError(w, err, namespace, s.Name)
+		return
+	}
+
+	handlerLog.WithFields(map[string]interface{}{
+		"namespace": namespace,
+		"service":   created.Name,
+	}).Info("Service created via API")
+	httputil.WriteJSON(w, http.StatusCreated, created)
+}
+
+func (a *Api) GetServicesHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, ok := a.namespaceParam(w, r)
+	if !ok {
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, a.Manager.GetServices(namespace))
+}
+
+func (a *Api) GetServiceHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, ok := a.namespaceParam(w, r)
+	if !ok {
+		return
+	}
+
+	name, err := httputil.GetURLParam(r, "service")
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s, err := a.Manager.GetService(namespace, name)
+	if err != nil {
+		writeServiceError(w, err, namespace, name)
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, s)
+}
+
+// UpdateServiceHandler replaces a service's spec.
+func (a *Api) UpdateServiceHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, ok := a.namespaceParam(w, r)
+	if !ok {
+		return
+	}
+
+	name, err := httputil.GetURLParam(r, "service")
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s, err := httputil.DecodeJSON[Service](r)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("%v", err))
+		return
+	}
+	s.Namespace = namespace
+	s.Name = name
+
+	updated, err := a.Manager.UpdateService(s)
+	if err != nil {
+		writeServiceError(w, err, namespace, name)
+		return
+	}
+
+	handlerLog.WithFields(map[string]interface{}{
+		"namespace": namespace,
+		"service":   name,
+	}).Info("Service updated via API")
+	httputil.WriteJSON(w, http.StatusOK, updated)
+}
+
+func (a *Api) DeleteServiceHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, ok := a.namespaceParam(w, r)
+	if !ok {
+		return
+	}
+
+	name, err := httputil.GetURLParam(r, "service")
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := a.Manager.DeleteService(namespace, name); err != nil {
+		writeServiceError(w, err, namespace, name)
+		return
+	}
+
+	handlerLog.WithFields(map[string]interface{}{
+		"namespace": namespace,
+		"service":   name,
+	}).Info("Service deleted via API")
+	httputil.WriteNoContent(w)
+}
+
+// GetIngressTableHandler serves the ingress routes of the namespaces the
+// caller may access, for ingress proxies running apart from the manager.
+func (a *Api) GetIngressTableHandler(w http.ResponseWriter, r *http.Request) {
+	table := a.Manager.ingressTable(func(namespace string) bool {
+		return auth.AllowedNamespace(r.Context(), namespace)
+	})
+
+	httputil.WriteJSON(w, http.StatusOK, table)
+}
@@ -0,0 +1,128 @@
+package manager
+
+import (
+	"Mine-Cube/pki"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+var ErrWorkerHostNotAllowed = errors.New("worker address is not in the approved worker hosts")
+
+// UseTLS makes the manager talk to workers over mutual TLS, using a
+// certificate issued by ca for the given hosts. It returns the TLS config for
+// the manager's own API, which accepts but does not require client
+// certificates.
+func (m *Manager) UseTLS(ca *pki.CA, hosts []string) (*tls.Config, error) {
+	m.CA = ca
+	m.KeyPair = &pki.KeyPair{}
+	m.certHosts = hosts
+
+	if err := m.issueCertificate(); err != nil {
+		return nil, err
+	}
+
+	m.client = &http.Client{
+		Transport: &http.Transport{TLSClientConfig: pki.ClientConfig(m.KeyPair, ca.Pool(), pki.OrgWorker)},
+		Timeout:   WORKER_REQUEST_TIMEOUT,
+	}
+	m.scheme = "https"
+
+	return pki.ServerConfig(m.KeyPair, ca.Pool(), tls.VerifyClientCertIfGiven, pki.OrgWorker), nil
+}
+
+func (m *Manager) issueCertificate() error {
+	certPEM, keyPEM, err := m.CA.Issue("manager", pki.OrgManager, m.certHosts)
+	if err != nil {
+		return err
+	}
+	return m.KeyPair.Set(certPEM, keyPEM)
+}
+
+// RenewCertificates keeps the manager's certificate renewed. Connections
+// made after a renewal use the new certificate.
+func (m *Manager) RenewCertificates() {
+	if m.KeyPair == nil {
+		return
+	}
+
+	m.KeyPair.KeepRenewed(m.issueCertificate)
+}
+
+// RegisterWorker issues a certificate to a worker and adds it to the pool
+// of workers tasks are scheduled on. Workers call it again to renew their
+// certificate before it expires.
+func (m *Manager) RegisterWorker(req pki.RegistrationRequest) (*pki.RegistrationResponse, error) {
+	if m.CA == nil {
+		return nil, fmt.Errorf("worker registration requires TLS to be enabled on the manager")
+	}
+	if req.Name == "" || req.Address == "" {
+		return nil, fmt.Errorf("worker name and address are required")
+	}
+
+	csr, err := pki.ParseCertificateRequestPEM([]byte(req.CSR))
+	if err != nil {
+		return nil, err
+	}
+
+	hosts, err := m.workerCertHosts(req)
+	if err != nil {
+		return nil, err
+	}
+
+	certPEM, err := m.CA.Sign(csr, req.Name, pki.OrgWorker, hosts)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.WorkerTaskMap[req.Address]; !ok {
+		m.Workers = append(m.Workers, req.Address)
+		m.WorkerTaskMap[req.Address] = []uuid.UUID{}
+
+		log.WithFields(map[string]interface{}{
+			"worker": req.Address,
+			"name":   req.Name,
+		}).Info("Worker registered")
+	}
+
+	return &pki.RegistrationResponse{Certificate: string(certPEM), CA: string(m.CA.CertPEM)}, nil
+}
+
+// workerCertHosts returns the hosts a worker's certificate is issued for:
+// the host of the address it registers at, which must be one of the
+// manager's WorkerCertHosts. Nothing the worker asks for is used, so a
+// worker can neither obtain a certificate for somebody else's name nor join
+// the pool at an address that was not approved. A worker listening on all
+// interfaces is reached over loopback and gets whichever of localhost and
+// 127.0.0.1 are approved.
+func (m *Manager) workerCertHosts(req pki.RegistrationRequest) ([]string, error) {
+	host, _, err := net.SplitHostPort(req.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid worker address %q: %w", req.Address, err)
+	}
+
+	requested := []string{host}
+	if host == "" {
+		requested = []string{"localhost", "127.0.0.1"}
+	}
+
+	hosts := []string{}
+	for _, h := range requested {
+		if slices.Contains(m.WorkerCertHosts, h) {
+			hosts = append(hosts, h)
+		}
+	}
+
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrWorkerHostNotAllowed, req.Address)
+	}
+	return hosts, nil
+}
@@ -0,0 +1,312 @@
+package manager
+
+import (
+	"Mine-Cube/task"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MAX_SPEC_REVISIONS is how many revisions of each task's spec are kept.
+var MAX_SPEC_REVISIONS = 10
+
+// UpdateStrategy says how a spec update reaches the task's container.
+type UpdateStrategy string
+
+const (
+	// UpdateNone means the update changed nothing
+	UpdateNone UpdateStrategy = "none"
+	// UpdateInPlace changes the running container through Docker
+	UpdateInPlace UpdateStrategy = "in-place"
+	// UpdateRecreate replaces the container on the same worker
+	UpdateRecreate UpdateStrategy = "recreate"
+)
+
+// inPlaceFields are the spec fields a running container can be updated
+// with; changing any other field recreates it.
+var inPlaceFields = map[string]bool{
+	"Cpu":           true,
+	"Memory":        true,
+	"RestartPolicy": true,
+}
+
+// SpecRevision is a task's spec as it was after it was submitted or
+// updated.
+type SpecRevision struct {
+	Revision int
+	Spec     task.TaskSpec
+	// Changed lists the fields that differ from the previous revision
+	Changed   []string
+	CreatedAt time.Time
+}
+
+// TaskUpdate is the outcome of an update.
+type TaskUpdate struct {
+	Task     *task.Task
+	Revision int
+	Changed  []string
+	Strategy UpdateStrategy
+}
+
+// specFields splits a spec into its fields, as JSON.
+func specFields(spec task.TaskSpec) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+// PatchSpec applies a patch to a task's spec. Each field in the patch
+// replaces the field of the spec as a whole; fields are matched in any
+// case, like encoding/json does. The ID and namespace cannot be changed.
+func PatchSpec(spec task.TaskSpec, patch map[string]json.RawMessage) (task.TaskSpec, error) {
+	fields, err := specFields(spec)
+	if err != nil {
+		return spec, err
+	}
+
+	errs := &task.ValidationError{}
+
+	for key, value := range patch {
+		name := ""
+		for field := range fields {
+			if strings.EqualFold(field, key) {
+				name = field
+				break
+			}
+		}
+
+		if name == "" {
+			errs.Add(key, "is not a field of a task spec")
+			continue
+		}
+		fields[name] = value
+	}
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return spec, err
+	}
+
+	var patched task.TaskSpec
+	if err := json.Unmarshal(data, &patched); err != nil {
+		return spec, err
+	}
+
+	if patched.ID != spec.ID {
+		errs.Add("ID", "cannot be changed")
+	}
+	if patched.Namespace != spec.Namespace {
+		errs.Add("Namespace", "cannot be changed")
+	}
+
+	return patched, errs.Err()
+}
+
+// changedFields lists, in order, the fields that differ between two specs.
+func changedFields(prev task.TaskSpec, next task.TaskSpec) []string {
+	a, _ := specFields(prev)
+	b, _ := specFields(next)
+
+	var changed []string
+	for name, value := range b {
+		if !bytes.Equal(a[name], value) {
+			changed = append(changed, name)
+		}
+	}
+
+	sort.Strings(changed)
+	return changed
+}
+
+// canUpdateInPlace reports whether all the changes can be made to the
+// task's container while it runs. Docker can change CPU and memory limits
+// but not lift them.
+func canUpdateInPlace(t *task.Task, updated *task.Task, changed []string) bool {
+	if t.State != task.Running && t.State != task.Paused {
+		return false
+	}
+
+	for _, field := range changed {
+		if !inPlaceFields[field] {
+			return false
+		}
+	}
+
+	return (updated.Cpu > 0 || t.Cpu == 0) && (updated.Memory > 0 || t.Memory == 0)
+}
+
+// applySpec copies the fields a spec decides from updated onto t, leaving
+// its state, container and placement as they are.
+func applySpec(t *task.Task, updated *task.Task) {
+	t.Name = updated.Name
+	t.Service = updated.Service
+	t.Image = updated.Image
+	t.Cpu = updated.Cpu
+	t.Memory = updated.Memory
+	t.Disk = updated.Disk
+	t.ExposedPorts = updated.ExposedPorts
+	t.PortBindings = updated.PortBindings
+	t.RestartPolicy = updated.RestartPolicy
+	t.Env = updated.Env
+	t.Secrets = updated.Secrets
+	t.Configs = updated.Configs
+	t.Volumes = updated.Volumes
+	t.Networks = updated.Networks
+	t.Labels = updated.Labels
+	t.Annotations = updated.Annotations
+	t.HealthCheck = updated.HealthCheck
+	t.StopSignal = updated.StopSignal
+	t.StopGracePeriod = updated.StopGracePeriod
+	t.PreStop = updated.PreStop
+}
+
+// UpdateTask changes a running task's spec to spec, which must have been
+// validated. CPU, memory and restart policy changes are made to the task's
+// container in place; any other change replaces the container on the same
+// worker, keeping the task's ID. An update that changes anything becomes a
+// new revision of the task's spec.
+func (m *Manager) UpdateTask(t *task.Task, spec task.TaskSpec, reason string) (TaskUpdate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	updated, err := spec.Task()
+	if err != nil {
+		return TaskUpdate{}, err
+	}
+
+	changed := changedFields(task.SpecOf(t), task.SpecOf(&updated))
+	if len(changed) == 0 {
+		current := *t
+		return TaskUpdate{Task: &current, Revision: t.Revision, Strategy: UpdateNone}, nil
+	}
+
+	w, assigned := m.TaskWorkerMap[t.ID]
+	if !assigned {
+		return TaskUpdate{}, ErrTaskNotRunning
+	}
+
+	strategy := UpdateRecreate
+	if canUpdateInPlace(t, &updated, changed) {
+		strategy = UpdateInPlace
+	} else if !task.ValidStateTransition(t.State, task.Restarting) {
+		return TaskUpdate{}, fmt.Errorf("%w: %s to %s", ErrInvalidStateTransition, t.State, task.Restarting)
+	}
+
+	if err := m.checkQuotaChange(t, &updated); err != nil {
+		return TaskUpdate{}, err
+	}
+	if err := m.validateSecretRefs(&updated); err != nil {
+		return TaskUpdate{}, err
+	}
+	if err := m.resolveConfigRefs(&updated); err != nil {
+		return TaskUpdate{}, err
+	}
+	if err := m.validateVolumes(&updated); err != nil {
+		return TaskUpdate{}, err
+	}
+	if err := validateNetworks(&updated); err != nil {
+		return TaskUpdate{}, err
+	}
+
+	// the task stays on its worker, so its named volumes must be there
+	volumeWorker, err := m.volumeWorker(&updated)
+	if err != nil {
+		return TaskUpdate{}, err
+	}
+	if volumeWorker != "" && volumeWorker != w {
+		return TaskUpdate{}, fmt.Errorf("%w: volumes are held by worker %s, not the task's worker %s", ErrInvalidVolume, volumeWorker, w)
+	}
+	// and so must the other tasks on its networks
+	if _, err := m.networkWorker(&updated, w); err != nil {
+		return TaskUpdate{}, err
+	}
+
+	if strategy == UpdateInPlace {
+		patched := *t
+		applySpec(&patched, &updated)
+		patched.Revision = t.Revision + 1
+
+		data, err := json.Marshal(patched)
+		if err != nil {
+			return TaskUpdate{}, err
+		}
+
+		if _, err := m.taskRequest(t, http.MethodPatch, "", data, "update"); err != nil {
+			return TaskUpdate{}, err
+		}
+	}
+
+	prevService := t.Service
+
+	applySpec(t, &updated)
+	t.Revision++
+	m.recordRevision(t, changed)
+
+	eventData := map[string]interface{}{
+		"Revision": t.Revision,
+		"Changed":  changed,
+		"Strategy": strategy,
+	}
+	if t.Service != prevService {
+		eventData["PreviousService"] = prevService
+	}
+	m.publish(EventTaskUpdated, t.ID, w, eventData)
+
+	log.WithFields(map[string]interface{}{
+		"task_id":  t.ID,
+		"revision": t.Revision,
+		"changed":  changed,
+		"strategy": strategy,
+	}).Info("Task updated")
+
+	if strategy == UpdateRecreate {
+		m.bindVolumes(t, w)
+		t.Health = task.Health{}
+		m.replaceContainer(t, task.SourceAPI, reason)
+	}
+
+	current := *t
+	return TaskUpdate{Task: &current, Revision: t.Revision, Changed: changed, Strategy: strategy}, nil
+}
+
+// recordRevision keeps the task's current spec as its latest revision,
+// dropping the oldest beyond MAX_SPEC_REVISIONS.
+func (m *Manager) recordRevision(t *task.Task, changed []string) {
+	revisions := append(m.SpecRevisions[t.ID], SpecRevision{
+		Revision:  t.Revision,
+		Spec:      task.SpecOf(t),
+		Changed:   changed,
+		CreatedAt: time.Now().UTC(),
+	})
+
+	if len(revisions) > MAX_SPEC_REVISIONS {
+		revisions = revisions[len(revisions)-MAX_SPEC_REVISIONS:]
+	}
+
+	m.SpecRevisions[t.ID] = revisions
+}
+
+// GetSpecRevisions returns the kept revisions of a task's spec, oldest
+// first.
+func (m *Manager) GetSpecRevisions(id uuid.UUID) []SpecRevision {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	revisions := make([]SpecRevision, len(m.SpecRevisions[id]))
+	copy(revisions, m.SpecRevisions[id])
+	return revisions
+}
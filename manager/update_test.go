@@ -0,0 +1,158 @@
+package manager
+
+import (
+	"Mine-Cube/task"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func runningTask(t *testing.T) *task.Task {
+	t.Helper()
+
+	spec := task.TaskSpec{
+		ID:            uuid.New(),
+		Name:          "web",
+		Namespace:     task.DefaultNamespace,
+		Image:         "nginx:1.27",
+		Cpu:           0.5,
+		Memory:        64 * 1024 * 1024,
+		Ports:         []string{"8080:80"},
+		RestartPolicy: "always",
+		Env:           []string{"A=1", "B=2"},
+	}
+
+	tk, err := spec.Task()
+	if err != nil {
+		t.Fatalf("Task(): %v", err)
+	}
+	tk.State = task.Running
+	return &tk
+}
+
+func patchOf(t *testing.T, body string) map[string]json.RawMessage {
+	t.Helper()
+
+	patch := map[string]json.RawMessage{}
+	if err := json.Unmarshal([]byte(body), &patch); err != nil {
+		t.Fatalf("bad patch %s: %v", body, err)
+	}
+	return patch
+}
+
+func TestPatchSpec(t *testing.T) {
+	tests := []struct {
+		name      string
+		patch     string
+		changed   []string
+		errFields []string
+		inPlace   bool
+	}{
+		{name: "empty patch", patch: `{}`},
+		{name: "same value", patch: `{"Cpu": 0.5}`},
+		{name: "cpu", patch: `{"Cpu": 1}`, changed: []string{"Cpu"}, inPlace: true},
+		{name: "fields in any case", patch: `{"memory": 134217728, "RESTARTPOLICY": "no"}`, changed: []string{"Memory", "RestartPolicy"}, inPlace: true},
+		{name: "lifting a limit", patch: `{"Cpu": 0}`, changed: []string{"Cpu"}},
+		{name: "image", patch: `{"Image": "nginx:1.28"}`, changed: []string{"Image"}},
+		{name: "env is replaced as a whole", patch: `{"Env": ["A=1"]}`, changed: []string{"Env"}},
+		{name: "same ports in another form", patch: `{"Ports": ["8080:80/tcp"]}`},
+		{name: "ports", patch: `{"Ports": ["9090:80"]}`, changed: []string{"Ports"}},
+		{name: "in place and recreate", patch: `{"Cpu": 1, "Image": "nginx:1.28"}`, changed: []string{"Cpu", "Image"}},
+		{name: "unknown field", patch: `{"Replicas": 3}`, errFields: []string{"Replicas"}},
+		{name: "id", patch: `{"ID": "` + uuid.NewString() + `"}`, errFields: []string{"ID"}},
+		{name: "namespace", patch: `{"Namespace": "other"}`, errFields: []string{"Namespace"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tk := runningTask(t)
+			prev := task.SpecOf(tk)
+
+			spec, err := PatchSpec(prev, patchOf(t, tt.patch))
+
+			var errFields []string
+			if err != nil {
+				var verr *task.ValidationError
+				if !errors.As(err, &verr) {
+					t.Fatalf("PatchSpec: %v", err)
+				}
+				for _, f := range verr.Fields {
+					errFields = append(errFields, f.Field)
+				}
+				sort.Strings(errFields)
+			}
+			if !reflect.DeepEqual(errFields, tt.errFields) {
+				t.Fatalf("invalid fields = %v, want %v", errFields, tt.errFields)
+			}
+			if err != nil {
+				return
+			}
+
+			// the patched spec goes through Task and back, as an update does
+			updated, err := spec.Task()
+			if err != nil {
+				t.Fatalf("Task(): %v", err)
+			}
+
+			changed := changedFields(prev, task.SpecOf(&updated))
+			if !reflect.DeepEqual(changed, tt.changed) {
+				t.Errorf("changed = %v, want %v", changed, tt.changed)
+			}
+
+			if len(changed) > 0 {
+				if got := canUpdateInPlace(tk, &updated, changed); got != tt.inPlace {
+					t.Errorf("canUpdateInPlace = %v, want %v", got, tt.inPlace)
+				}
+			}
+		})
+	}
+}
+
+func TestChangedFieldsOfEqualSpecs(t *testing.T) {
+	tk := runningTask(t)
+
+	if changed := changedFields(task.SpecOf(tk), task.SpecOf(tk)); len(changed) != 0 {
+		t.Errorf("changed = %v for the same spec", changed)
+	}
+}
+
+func TestCanUpdateInPlaceNeedsRunningContainer(t *testing.T) {
+	tk := runningTask(t)
+	updated := *tk
+	updated.Cpu = 1
+
+	for _, state := range []task.State{task.Running, task.Paused, task.Failed, task.Scheduled} {
+		tk.State = state
+		want := state == task.Running || state == task.Paused
+		if got := canUpdateInPlace(tk, &updated, []string{"Cpu"}); got != want {
+			t.Errorf("canUpdateInPlace while %s = %v, want %v", state, got, want)
+		}
+	}
+}
+
+func TestApplySpecKeepsStateAndPlacement(t *testing.T) {
+	tk := runningTask(t)
+	tk.ContainerID = "abc"
+	tk.Revision = 3
+
+	spec := task.SpecOf(tk)
+	spec.Image = "nginx:1.28"
+	spec.Ports = []string{"9090:80"}
+	updated, err := spec.Task()
+	if err != nil {
+		t.Fatalf("Task(): %v", err)
+	}
+
+	applySpec(tk, &updated)
+
+	if tk.State != task.Running || tk.ContainerID != "abc" || tk.Revision != 3 {
+		t.Errorf("applySpec changed state, container or revision: %s %q %d", tk.State, tk.ContainerID, tk.Revision)
+	}
+	if got := task.SpecOf(tk); !reflect.DeepEqual(got, task.SpecOf(&updated)) {
+		t.Errorf("spec after applySpec = %+v, want %+v", got, task.SpecOf(&updated))
+	}
+}
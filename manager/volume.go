@@ -0,0 +1,220 @@
+package manager
+
+import (
+	"Mine-Cube/task"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+var ErrVolumeNotFound = errors.New("volume not found")
+var ErrVolumeExists = errors.New("volume already exists")
+var ErrVolumeInUse = errors.New("volume is used by active tasks")
+var ErrInvalidVolume = errors.New("invalid volume")
+
+// Volume is a named volume of a namespace. Its data lives on a single
+// worker, chosen when the first task using it is scheduled, and every later
+// task using it is scheduled there too.
+type Volume struct {
+	Name      string
+	Namespace string
+	// Worker holding the volume's data; empty until first used
+	Worker    string
+	CreatedAt time.Time
+}
+
+func volumeKey(namespace string, name string) string {
+	return namespace + "/" + name
+}
+
+func (m *Manager) CreateVolume(namespace string, name string) (*Volume, error) {
+	// volume names become part of Docker volume names, which use "_" as a
+	// separator
+	if err := ValidateNamespaceName(name); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidVolume, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.VolumeDb[volumeKey(namespace, name)]; ok {
+		return nil, ErrVolumeExists
+	}
+
+	v := &Volume{Name: name, Namespace: namespace, CreatedAt: time.Now().UTC()}
+	m.VolumeDb[volumeKey(namespace, name)] = v
+
+	log.WithFields(map[string]interface{}{
+		"namespace": namespace,
+		"volume":    name,
+	}).Info("Volume created")
+
+	created := *v
+	return &created, nil
+}
+
+func (m *Manager) GetVolume(namespace string, name string) (*Volume, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	v, ok := m.VolumeDb[volumeKey(namespace, name)]
+	if !ok {
+		return nil, ErrVolumeNotFound
+	}
+
+	volume := *v
+	return &volume, nil
+}
+
+func (m *Manager) GetVolumes(namespace string) []*Volume {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	volumes := []*Volume{}
+	for _, v := range m.VolumeDb {
+		if v.Namespace == namespace {
+			volume := *v
+			volumes = append(volumes, &volume)
+		}
+	}
+
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].Name < volumes[j].Name
+	})
+
+	return volumes
+}
+
+// DeleteVolume removes a volume that no active task uses, together with its
+// data on the worker holding it.
+func (m *Manager) DeleteVolume(namespace string, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := volumeKey(namespace, name)
+	v, ok := m.VolumeDb[key]
+	if !ok {
+		return ErrVolumeNotFound
+	}
+
+	for _, t := range m.TaskDb {
+		if namespaceOf(t) == namespace && isActive(t) && usesVolume(t, name) {
+			return ErrVolumeInUse
+		}
+	}
+
+	if v.Worker != "" {
+		path := "/volumes/" + task.DockerVolumeName(namespace, name)
+		resp, err := m.workerRequest(http.MethodDelete, v.Worker, path, nil)
+		if err != nil {
+			return fmt.Errorf("error connecting to worker %s: %w", v.Worker, err)
+		}
+		resp.Body.Close()
+
+		// the volume may never have been created if its first task
+		// failed to start
+		if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+			return fmt.Errorf("worker %s could not remove the volume (%d)", v.Worker, resp.StatusCode)
+		}
+	}
+
+	delete(m.VolumeDb, key)
+
+	log.WithFields(map[string]interface{}{
+		"namespace": namespace,
+		"volume":    name,
+		"worker":    v.Worker,
+	}).Info("Volume deleted")
+
+	return nil
+}
+
+func usesVolume(t *task.Task, name string) bool {
+	for _, v := range t.Volumes {
+		if v.Type == task.VolumeNamed && v.Source == name {
+			return true
+		}
+	}
+	return false
+}
+
+// validateVolumes checks a task's volume mounts. Whether a bind mount is
+// allowed is up to the worker that runs the task.
+func (m *Manager) validateVolumes(t *task.Task) error {
+	for i, v := range t.Volumes {
+		field := fmt.Sprintf("Volumes[%d]", i)
+		if !filepath.IsAbs(v.Target) {
+			return specFieldError(field, fmt.Errorf("%w: target %q must be an absolute path", ErrInvalidVolume, v.Target))
+		}
+
+		switch v.Type {
+		case task.VolumeBind:
+			if !filepath.IsAbs(v.Source) {
+				return specFieldError(field, fmt.Errorf("%w: bind source %q must be an absolute path", ErrInvalidVolume, v.Source))
+			}
+		case task.VolumeNamed:
+			if _, ok := m.VolumeDb[volumeKey(namespaceOf(t), v.Source)]; !ok {
+				return specFieldError(field, fmt.Errorf("%w: %s/%s", ErrVolumeNotFound, namespaceOf(t), v.Source))
+			}
+		case task.VolumeTmpfs:
+			if v.Source != "" {
+				return specFieldError(field, fmt.Errorf("%w: tmpfs mount at %q takes no source", ErrInvalidVolume, v.Target))
+			}
+		default:
+			return specFieldError(field, fmt.Errorf("%w: unknown type %q", ErrInvalidVolume, v.Type))
+		}
+	}
+
+	return nil
+}
+
+// volumeWorker returns the worker holding the named volumes a task uses, or
+// "" when none of them is bound to a worker yet.
+func (m *Manager) volumeWorker(t *task.Task) (string, error) {
+	worker := ""
+
+	for _, mount := range t.Volumes {
+		if mount.Type != task.VolumeNamed {
+			continue
+		}
+
+		v, ok := m.VolumeDb[volumeKey(namespaceOf(t), mount.Source)]
+		if !ok {
+			return "", fmt.Errorf("%w: %s/%s", ErrVolumeNotFound, namespaceOf(t), mount.Source)
+		}
+
+		if v.Worker == "" {
+			continue
+		}
+		if worker != "" && v.Worker != worker {
+			return "", fmt.Errorf("task uses volumes held by different workers: %s and %s", worker, v.Worker)
+		}
+		worker = v.Worker
+	}
+
+	return worker, nil
+}
+
+// bindVolumes records that the task's unbound named volumes now live on
+// the given worker.
+func (m *Manager) bindVolumes(t *task.Task, worker string) {
+	for _, mount := range t.Volumes {
+		if mount.Type != task.VolumeNamed {
+			continue
+		}
+
+		v, ok := m.VolumeDb[volumeKey(namespaceOf(t), mount.Source)]
+		if ok && v.Worker == "" {
+			v.Worker = worker
+
+			log.WithFields(map[string]interface{}{
+				"namespace": v.Namespace,
+				"volume":    v.Name,
+				"worker":    worker,
+			}).Info("Volume bound to worker")
+		}
+	}
+}
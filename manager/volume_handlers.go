@@ -0,0 +1,107 @@
+package manager
+
+import (
+	httputil "Mine-Cube/utils/http"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+type volumeRequest struct {
+	Name string
+}
+
+func writeVolumeError(w http.ResponseWriter, err error, namespace string, name string) {
+	switch {
+	case errors.Is(err, ErrVolumeNotFound):
+		httputil.WriteError(w, http.StatusNotFound, fmt.Sprintf("No volume found with name: %s/%s", namespace, name))
+	case errors.Is(err, ErrVolumeExists):
+		httputil.WriteError(w, http.StatusConflict, fmt.Sprintf("Volume already exists: %s/%s", namespace, name))
+	case errors.Is(err, ErrVolumeInUse):
+		httputil.WriteError(w, http.StatusConflict, fmt.Sprintf("Volume %s/%s is used by active tasks", namespace, name))
+	case errors.Is(err, ErrInvalidVolume):
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+	default:
+		httputil.WriteError(w, http.StatusBadGateway, err.Error())
+	}
+}
+
+func (a *Api) CreateVolumeHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, ok := a.namespaceParam(w, r)
+	if !ok {
+		return
+	}
+
+	req, err := httputil.DecodeJSON[volumeRequest](r)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("%v", err))
+		return
+	}
+
+	v, err := a.Manager.CreateVolume(namespace, req.Name)
+	if err != nil {
+		writeVolumeError(w, err, namespace, req.Name)
+		return
+	}
+
+	handlerLog.WithFields(map[string]interface{}{
+		"namespace": namespace,
+		"volume":    v.Name,
+	}).Info("Volume created via API")
+	httputil.WriteJSON(w, http.StatusCreated, v)
+}
+
+func (a *Api) GetVolumesHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, ok := a.namespaceParam(w, r)
+	if !ok {
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, a.Manager.GetVolumes(namespace))
+}
+
+func (a *Api) GetVolumeHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, ok := a.namespaceParam(w, r)
+	if !ok {
+		return
+	}
+
+	name, err := httputil.GetURLParam(r, "volume")
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	v, err := a.Manager.GetVolume(namespace, name)
+	if err != nil {
+		writeVolumeError(w, err, namespace, name)
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, v)
+}
+
+// DeleteVolumeHandler deletes a volume and the data it holds.
+func (a *Api) DeleteVolumeHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, ok := a.namespaceParam(w, r)
+	if !ok {
+		return
+	}
+
+	name, err := httputil.GetURLParam(r, "volume")
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := a.Manager.DeleteVolume(namespace, name); err != nil {
+		writeVolumeError(w, err, namespace, name)
+		return
+	}
+
+	handlerLog.WithFields(map[string]interface{}{
+		"namespace": namespace,
+		"volume":    name,
+	}).Info("Volume deleted via API")
+	httputil.WriteNoContent(w)
+}
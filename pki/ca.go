@@ -0,0 +1,234 @@
+package pki
+
+import (
+	"Mine-Cube/logger"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var log = logger.GetLogger("pki")
+
+var CA_TTL = 10 * 365 * 24 * time.Hour
+var CERT_TTL = 7 * 24 * time.Hour
+
+// Certificates are issued with one of these organizations, which tells
+// the holder's role apart when it presents the certificate as a client.
+const (
+	OrgManager = "cube:manager"
+	OrgWorker  = "cube:worker"
+)
+
+const (
+	CACertFile = "ca.crt"
+	CAKeyFile  = "ca.key"
+)
+
+// CA is the cluster's certificate authority. It is kept by the manager and
+// signs the certificates of the manager and of every worker.
+type CA struct {
+	Cert    *x509.Certificate
+	CertPEM []byte
+	key     crypto.Signer
+}
+
+// LoadOrCreateCA reads the CA certificate and key from dir, creating and
+// saving a new CA there when none exists yet.
+func LoadOrCreateCA(dir string) (*CA, error) {
+	certPath := filepath.Join(dir, CACertFile)
+	keyPath := filepath.Join(dir, CAKeyFile)
+
+	certPEM, err := os.ReadFile(certPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return createCA(dir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA certificate: %w", err)
+	}
+
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA key: %w", err)
+	}
+
+	cert, err := ParseCertificatePEM(certPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CA{Cert: cert, CertPEM: certPEM, key: key}, nil
+}
+
+func createCA(dir string) (*CA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("error generating CA key: %w", err)
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "cube-ca", Organization: []string{OrgManager}},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(CA_TTL),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating CA certificate: %w", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPEM, err := EncodePrivateKeyPEM(key)
+	if err != nil {
+		return nil, err
+	}
+	certPEM := EncodeCertificatePEM(der)
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating CA directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, CAKeyFile), keyPEM, 0600); err != nil {
+		return nil, fmt.Errorf("error writing CA key: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, CACertFile), certPEM, 0644); err != nil {
+		return nil, fmt.Errorf("error writing CA certificate: %w", err)
+	}
+
+	log.WithField("dir", dir).Info("Created new certificate authority")
+
+	return &CA{Cert: cert, CertPEM: certPEM, key: key}, nil
+}
+
+func newSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("error generating serial number: %w", err)
+	}
+	return serial, nil
+}
+
+// Pool returns a certificate pool that trusts only this CA.
+func (ca *CA) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Cert)
+	return pool
+}
+
+// Sign issues a certificate for the public key of a certificate request.
+// The subject and names in the request are ignored: the certificate is
+// always issued to commonName in organization, for the given hosts, so that
+// a requester cannot pick its own role. The certificate is valid for both
+// server and client authentication.
+func (ca *CA) Sign(csr *x509.CertificateRequest, commonName string, organization string, hosts []string) ([]byte, error) {
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid certificate request signature: %w", err)
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{organization}},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(CERT_TTL),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if h != "" {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	if template.NotAfter.After(ca.Cert.NotAfter) {
+		template.NotAfter = ca.Cert.NotAfter
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Cert, csr.PublicKey, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("error signing certificate: %w", err)
+	}
+
+	log.WithFields(map[string]interface{}{
+		"common_name":  commonName,
+		"organization": organization,
+		"hosts":        hosts,
+		"not_after":    template.NotAfter,
+	}).Info("Issued certificate")
+
+	return EncodeCertificatePEM(der), nil
+}
+
+// Issue generates a new key and has the CA sign a certificate for it. The
+// manager uses it for its own certificate.
+func (ca *CA) Issue(commonName string, organization string, hosts []string) (certPEM []byte, keyPEM []byte, err error) {
+	csrPEM, keyPEM, err := NewCertificateRequest(commonName, hosts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	csr, err := ParseCertificateRequestPEM(csrPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM, err = ca.Sign(csr, commonName, organization, hosts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return certPEM, keyPEM, nil
+}
+
+// RegistrationRequest is sent by a worker to the manager to join the cluster
+// and to renew its certificate.
+type RegistrationRequest struct {
+	Name string
+	// Address is the host:port the manager reaches the worker's API at
+	Address string
+	// CSR is a PEM encoded certificate request
+	CSR string
+}
+
+// RegistrationResponse carries the worker's new certificate and the CA
+// certificate to trust the manager and other workers with, both PEM encoded.
+type RegistrationResponse struct {
+	Certificate string
+	CA          string
+}
@@ -0,0 +1,155 @@
+package pki
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var CERT_RENEW_CHECK_INTERVAL = time.Minute
+
+// CERT_ISSUE_RETRY_INTERVAL is how soon a failed first issuance is retried.
+var CERT_ISSUE_RETRY_INTERVAL = 5 * time.Second
+
+// RENEW_BEFORE_FRACTION is how much of a certificate's lifetime may be left
+// before it is renewed.
+var RENEW_BEFORE_FRACTION = 1.0 / 3
+
+// KeyPair holds the current certificate of a manager or worker. The TLS
+// configs built from it look the certificate up on every handshake, so a
+// renewed certificate takes effect without restarting any listener or
+// client.
+type KeyPair struct {
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// Set replaces the current certificate.
+func (kp *KeyPair) Set(certPEM []byte, keyPEM []byte) error {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("error loading key pair: %w", err)
+	}
+
+	kp.mu.Lock()
+	kp.cert = &cert
+	kp.mu.Unlock()
+
+	return nil
+}
+
+// Leaf returns the current certificate, or nil before one has been set.
+func (kp *KeyPair) Leaf() *x509.Certificate {
+	kp.mu.RLock()
+	defer kp.mu.RUnlock()
+
+	if kp.cert == nil {
+		return nil
+	}
+	return kp.cert.Leaf
+}
+
+// NeedsRenewal reports whether there is no certificate yet or the current
+// one is close to expiring.
+func (kp *KeyPair) NeedsRenewal() bool {
+	leaf := kp.Leaf()
+	if leaf == nil {
+		return true
+	}
+
+	lifetime := leaf.NotAfter.Sub(leaf.NotBefore)
+	renewAt := leaf.NotAfter.Add(-time.Duration(float64(lifetime) * RENEW_BEFORE_FRACTION))
+
+	return time.Now().After(renewAt)
+}
+
+func (kp *KeyPair) current() (*tls.Certificate, error) {
+	kp.mu.RLock()
+	defer kp.mu.RUnlock()
+
+	if kp.cert == nil {
+		return nil, fmt.Errorf("no certificate issued yet")
+	}
+	return kp.cert, nil
+}
+
+// KeepRenewed calls renew whenever the certificate needs renewing. Failed
+// renewals are retried on the next check, while the old certificate is
+// still valid.
+func (kp *KeyPair) KeepRenewed(renew func() error) {
+	for {
+		interval := CERT_RENEW_CHECK_INTERVAL
+
+		if kp.NeedsRenewal() {
+			if err := renew(); err != nil {
+				log.Warnf("Error renewing certificate: %v", err)
+				if kp.Leaf() == nil {
+					interval = CERT_ISSUE_RETRY_INTERVAL
+				}
+			} else {
+				log.WithField("not_after", kp.Leaf().NotAfter).Info("Certificate renewed")
+			}
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// ServerConfig returns a TLS config for an API server using kp's current
+// certificate. Client certificates are checked against roots according to
+// clientAuth, and must have been issued to one of orgs.
+func ServerConfig(kp *KeyPair, roots *x509.CertPool, clientAuth tls.ClientAuthType, orgs ...string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return kp.current()
+		},
+		ClientCAs:        roots,
+		ClientAuth:       clientAuth,
+		VerifyConnection: verifyOrganization(orgs),
+	}
+}
+
+// ClientConfig returns a TLS config that trusts servers with a certificate
+// from roots issued to one of orgs, and presents kp's current certificate,
+// if any, when the server asks for one.
+func ClientConfig(kp *KeyPair, roots *x509.CertPool, orgs ...string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    roots,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := kp.current()
+			if err != nil {
+				// an empty certificate lets the server decide
+				return &tls.Certificate{}, nil
+			}
+			return cert, nil
+		},
+		VerifyConnection: verifyOrganization(orgs),
+	}
+}
+
+// verifyOrganization checks that the peer's certificate, which has already
+// been verified against the CA, was issued to one of orgs. Managers and
+// workers share the CA, so the chain alone does not tell a worker from the
+// manager. Peers without a certificate are left to ClientAuth.
+func verifyOrganization(orgs []string) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return nil
+		}
+
+		leaf := cs.PeerCertificates[0]
+		for _, org := range leaf.Subject.Organization {
+			if slices.Contains(orgs, org) {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("certificate of %q is not issued to %s", leaf.Subject.CommonName, strings.Join(orgs, " or "))
+	}
+}
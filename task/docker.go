@@ -2,13 +2,21 @@ package task
 
 import (
 	"Mine-Cube/logger"
+	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"math"
 	"os"
+	"time"
 
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/docker/go-connections/nat"
@@ -33,9 +41,9 @@ type Config struct {
 	Cmd []string
 	// Image to use for the container
 	Image string
-	// CPU to use for the container
+	// CPU limit of the container, in cores; 0 for none
 	Cpu float64
-	// Memory to use for the container
+	// Memory limit of the container, in bytes; 0 for none
 	Memory int64
 	// Disk to use for the container
 	Disk int64
@@ -43,6 +51,19 @@ type Config struct {
 	Env []string
 	// Restart policy to use for the container
 	RestartPolicy string
+	// Labels to set on the container
+	Labels map[string]string
+	// Mounts to add to the container
+	Mounts []mount.Mount
+	// Docker networks to connect the container to
+	Networks []string
+	// Names the container is reached by on its networks
+	NetworkAliases []string
+	// Signal sent to stop the container; empty means the image's own
+	StopSignal string
+	// Seconds the container has to exit after its stop signal before it
+	// is killed; 0 means Docker's default
+	StopTimeout int
 }
 
 type Docker struct {
@@ -52,11 +73,47 @@ type Docker struct {
 
 type DockerResult struct {
 	Error error
-	// could be start | stop
+	// could be start | stop | kill | pause | unpause | update
 	Action      string
 	ContainerId string
 	// arbitrary text to provide information about the result
 	Result string
+	// StopMode and Exit say how a stopped container ended; the mode is
+	// empty when the container had already exited
+	StopMode StopMode
+	Exit     *ExitStatus
+}
+
+// LogOptions selects which part of a container's output to read.
+type LogOptions struct {
+	// Keep the stream open and send new output as it is written
+	Follow bool
+	// Number of lines to return from the end of the logs, or "all"
+	Tail string
+	// Only return logs after/before this point, as a timestamp or a
+	// relative duration such as "10m"
+	Since string
+	Until string
+	// Prefix every line with its RFC3339Nano timestamp
+	Timestamps bool
+	Stdout     bool
+	Stderr     bool
+}
+
+// ExecOptions describes a command to run inside a running container.
+type ExecOptions struct {
+	Cmd        []string
+	Env        []string
+	User       string
+	WorkingDir string
+	// Allocate a pseudo-terminal and attach stdin, for interactive sessions
+	Tty bool
+}
+
+type ExecResult struct {
+	ExitCode int
+	Stdout   string
+	Stderr   string
 }
 
 type DockerInspectResponse struct {
@@ -64,13 +121,109 @@ type DockerInspectResponse struct {
 	Container *container.InspectResponse
 }
 
+// Container labels set for every task, alongside the task's own labels.
+// Annotations are added under AnnotationLabelPrefix.
+const (
+	TaskIDLabel           = "cube.task.id"
+	TaskNameLabel         = "cube.task.name"
+	NamespaceLabel        = "cube.namespace"
+	AnnotationLabelPrefix = "cube.annotation."
+)
+
+func containerLabels(t *Task) map[string]string {
+	labels := make(map[string]string, len(t.Labels)+len(t.Annotations)+3)
+
+	for k, v := range t.Labels {
+		labels[k] = v
+	}
+	for k, v := range t.Annotations {
+		labels[AnnotationLabelPrefix+k] = v
+	}
+
+	labels[TaskIDLabel] = t.ID.String()
+	labels[TaskNameLabel] = t.Name
+	labels[NamespaceLabel] = t.Namespace
+
+	return labels
+}
+
+// VolumeNameLabel holds a named volume's name, and NetworkNameLabel a
+// network's, next to NamespaceLabel.
+const (
+	VolumeNameLabel  = "cube.volume"
+	NetworkNameLabel = "cube.network"
+)
+
+// DockerNetworkName is the name of a namespace's network on a worker.
+func DockerNetworkName(namespace string, name string) string {
+	return fmt.Sprintf("cube_%s_%s", namespace, name)
+}
+
+func networkAliases(t *Task) []string {
+	var aliases []string
+	if t.Name != "" {
+		aliases = append(aliases, t.Name)
+	}
+	if t.Service != "" && t.Service != t.Name {
+		aliases = append(aliases, t.Service)
+	}
+	return aliases
+}
+
+// DockerVolumeName is the name of a namespace's named volume on the worker
+// holding it. Neither namespaces nor volume names contain underscores.
+func DockerVolumeName(namespace string, name string) string {
+	return fmt.Sprintf("cube_%s_%s", namespace, name)
+}
+
+func volumeMounts(t *Task) []mount.Mount {
+	var mounts []mount.Mount
+
+	for _, v := range t.Volumes {
+		m := mount.Mount{Target: v.Target, ReadOnly: v.ReadOnly}
+
+		switch v.Type {
+		case VolumeBind:
+			m.Type = mount.TypeBind
+			m.Source = v.Source
+		case VolumeNamed:
+			m.Type = mount.TypeVolume
+			m.Source = DockerVolumeName(t.Namespace, v.Source)
+		case VolumeTmpfs:
+			m.Type = mount.TypeTmpfs
+			m.TmpfsOptions = &mount.TmpfsOptions{SizeBytes: v.Size}
+		}
+
+		mounts = append(mounts, m)
+	}
+
+	return mounts
+}
+
+// NewConfig returns the container config of a task. It carries the task's
+// CPU and memory, so every container started from it is limited to them,
+// as well as containers updated in place.
 func NewConfig(t *Task) Config {
+	var networks []string
+	for _, n := range t.Networks {
+		networks = append(networks, DockerNetworkName(t.Namespace, n))
+	}
+
 	return Config{
-		Name:          t.Name,
-		ExposedPorts:  t.ExposedPorts,
-		PortBindings:  t.PortBindings,
-		Image:         t.Image,
-		RestartPolicy: t.RestartPolicy,
+		Name:           t.Name,
+		ExposedPorts:   t.ExposedPorts,
+		PortBindings:   t.PortBindings,
+		Image:          t.Image,
+		Cpu:            t.Cpu,
+		Memory:         t.Memory,
+		RestartPolicy:  t.RestartPolicy,
+		Env:            t.Env,
+		Labels:         containerLabels(t),
+		Mounts:         volumeMounts(t),
+		Networks:       networks,
+		NetworkAliases: networkAliases(t),
+		StopSignal:     t.StopSignal,
+		StopTimeout:    int(t.GracePeriod() / time.Second),
 	}
 }
 
@@ -114,6 +267,11 @@ func (d *Docker) Run() DockerResult {
 		Tty:          false,
 		Env:          d.Config.Env,
 		ExposedPorts: d.Config.ExposedPorts,
+		Labels:       d.Config.Labels,
+		StopSignal:   d.Config.StopSignal,
+	}
+	if d.Config.StopTimeout > 0 {
+		containerConfig.StopTimeout = &d.Config.StopTimeout
 	}
 
 	resources := container.Resources{
@@ -126,12 +284,25 @@ func (d *Docker) Run() DockerResult {
 		RestartPolicy:   restartPolicy,
 		PublishAllPorts: false,
 		PortBindings:    d.Config.PortBindings,
+		Mounts:          d.Config.Mounts,
+	}
+
+	// The container is created on its first network and connected to the
+	// others before it starts
+	var networkingConfig *network.NetworkingConfig
+	if len(d.Config.Networks) > 0 {
+		hostConfig.NetworkMode = container.NetworkMode(d.Config.Networks[0])
+		networkingConfig = &network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{
+				d.Config.Networks[0]: {Aliases: d.Config.NetworkAliases},
+			},
+		}
 	}
 
 	// Creating container
 	log.WithField("name", d.Config.Name).Info("Creating container")
 
-	res, err := d.Client.ContainerCreate(ctx, &containerConfig, &hostConfig, nil, nil, d.Config.Name)
+	res, err := d.Client.ContainerCreate(ctx, &containerConfig, &hostConfig, networkingConfig, nil, d.Config.Name)
 	if err != nil {
 		log.WithField("name", d.Config.Name).Errorf("Failed to create container: %v", err)
 		return DockerResult{Error: err}
@@ -146,6 +317,22 @@ func (d *Docker) Run() DockerResult {
 
 	containerID := res.ID
 
+	for i := 1; i < len(d.Config.Networks); i++ {
+		err = d.Client.NetworkConnect(ctx, d.Config.Networks[i], containerID, &network.EndpointSettings{Aliases: d.Config.NetworkAliases})
+		if err != nil {
+			log.WithFields(map[string]interface{}{
+				"container_id": containerID,
+				"network":      d.Config.Networks[i],
+			}).Errorf("Failed to connect container to network: %v", err)
+
+			// the container never started, so nothing else removes it
+			if rmErr := d.Client.ContainerRemove(ctx, containerID, container.RemoveOptions{RemoveVolumes: true}); rmErr != nil {
+				log.WithField("container_id", containerID).Errorf("Failed to remove container: %v", rmErr)
+			}
+			return DockerResult{Error: err}
+		}
+	}
+
 	// Starting container
 	log.WithField("container_id", containerID).Info("Starting container")
 
@@ -155,40 +342,187 @@ func (d *Docker) Run() DockerResult {
 		return DockerResult{Error: err}
 	}
 
-	// d.Config.Runtime.ContainerID = res.ID
+	return DockerResult{
+		ContainerId: containerID,
+		Action:      "start",
+		Result:      "success",
+	}
+}
 
-	out, err := d.Client.ContainerLogs(
-		ctx,
-		containerID,
-		container.LogsOptions{ShowStdout: true, ShowStderr: true},
-	)
+// EnsureVolume creates a named volume unless it already exists.
+func (d *Docker) EnsureVolume(ctx context.Context, name string, labels map[string]string) error {
+	if _, err := d.Client.VolumeInspect(ctx, name); err == nil {
+		return nil
+	}
+
+	log.WithField("volume", name).Info("Creating volume")
+
+	_, err := d.Client.VolumeCreate(ctx, volume.CreateOptions{Name: name, Labels: labels})
 	if err != nil {
-		log.WithField("container_id", containerID).Errorf("Failed to get container logs: %v", err)
-		return DockerResult{Error: err}
+		log.WithField("volume", name).Errorf("Failed to create volume: %v", err)
+		return err
 	}
 
-	stdcopy.StdCopy(os.Stdout, os.Stderr, out)
+	return nil
+}
 
-	return DockerResult{
-		ContainerId: containerID,
-		Action:      "start",
-		Result:      "success",
+// ListVolumes returns the volumes that carry the given label.
+func (d *Docker) ListVolumes(ctx context.Context, label string) ([]*volume.Volume, error) {
+	resp, err := d.Client.VolumeList(ctx, volume.ListOptions{Filters: filters.NewArgs(filters.Arg("label", label))})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Volumes, nil
+}
+
+func (d *Docker) InspectVolume(ctx context.Context, name string) (volume.Volume, error) {
+	return d.Client.VolumeInspect(ctx, name)
+}
+
+func (d *Docker) RemoveVolume(ctx context.Context, name string) error {
+	log.WithField("volume", name).Info("Removing volume")
+
+	if err := d.Client.VolumeRemove(ctx, name, false); err != nil {
+		log.WithField("volume", name).Errorf("Failed to remove volume: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// EnsureNetwork creates a bridge network unless it already exists.
+func (d *Docker) EnsureNetwork(ctx context.Context, name string, labels map[string]string) error {
+	if _, err := d.Client.NetworkInspect(ctx, name, network.InspectOptions{}); err == nil {
+		return nil
+	}
+
+	log.WithField("network", name).Info("Creating network")
+
+	_, err := d.Client.NetworkCreate(ctx, name, network.CreateOptions{Driver: "bridge", Labels: labels})
+	if err != nil {
+		log.WithField("network", name).Errorf("Failed to create network: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// ListNetworks returns the networks that carry the given label, with the
+// containers attached to them.
+func (d *Docker) ListNetworks(ctx context.Context, label string) ([]network.Inspect, error) {
+	summaries, err := d.Client.NetworkList(ctx, network.ListOptions{Filters: filters.NewArgs(filters.Arg("label", label))})
+	if err != nil {
+		return nil, err
+	}
+
+	// the list leaves out attached containers
+	networks := make([]network.Inspect, 0, len(summaries))
+	for _, s := range summaries {
+		n, err := d.Client.NetworkInspect(ctx, s.ID, network.InspectOptions{})
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, n)
+	}
+
+	return networks, nil
+}
+
+func (d *Docker) RemoveNetwork(ctx context.Context, name string) error {
+	log.WithField("network", name).Info("Removing network")
+
+	if err := d.Client.NetworkRemove(ctx, name); err != nil {
+		log.WithField("network", name).Errorf("Failed to remove network: %v", err)
+		return err
 	}
+
+	return nil
 }
 
+// killedExitCode is the exit code of a container ended by SIGKILL.
+const killedExitCode = 128 + 9
+
+// Stop sends the container its stop signal and removes it once it has
+// exited. A container that is still running when its grace period is over
+// is killed.
 func (d *Docker) Stop(id string) DockerResult {
+	return d.stop(id, false)
+}
+
+// Kill is Stop without the grace period: the container is sent SIGKILL
+// right away.
+func (d *Docker) Kill(id string) DockerResult {
+	return d.stop(id, true)
+}
+
+func (d *Docker) stop(id string, force bool) DockerResult {
 	ctx := context.Background()
 
-	log.WithField("container_id", id).Info("Stopping container")
+	action := "stop"
+	if force {
+		action = "kill"
+	}
 
-	err := d.Client.ContainerStop(ctx, id, container.StopOptions{})
+	resp, err := d.Client.ContainerInspect(ctx, id)
 	if err != nil {
-		log.WithField("container_id", id).Errorf("Failed to stop container: %v", err)
+		log.WithField("container_id", id).Errorf("Failed to inspect container: %v", err)
 		return DockerResult{Error: err}
 	}
 
+	// a paused container could not act on its stop signal
+	if resp.State != nil && resp.State.Paused {
+		if err := d.Client.ContainerUnpause(ctx, id); err != nil {
+			log.WithField("container_id", id).Errorf("Failed to unpause container: %v", err)
+			return DockerResult{Error: err}
+		}
+	}
+
+	var mode StopMode
+	if resp.State != nil && resp.State.Running {
+		if force {
+			log.WithField("container_id", id).Info("Killing container")
+			err = d.kill(ctx, id)
+		} else {
+			log.WithFields(map[string]interface{}{
+				"container_id": id,
+				"signal":       d.Config.StopSignal,
+				"grace_period": d.Config.StopTimeout,
+			}).Info("Stopping container")
+
+			opts := container.StopOptions{Signal: d.Config.StopSignal}
+			if d.Config.StopTimeout > 0 {
+				opts.Timeout = &d.Config.StopTimeout
+			}
+			err = d.Client.ContainerStop(ctx, id, opts)
+		}
+		if err != nil {
+			log.WithField("container_id", id).Errorf("Failed to %s container: %v", action, err)
+			return DockerResult{Error: err}
+		}
+
+		resp, err = d.Client.ContainerInspect(ctx, id)
+		if err != nil {
+			log.WithField("container_id", id).Errorf("Failed to inspect container: %v", err)
+			return DockerResult{Error: err}
+		}
+
+		// Docker kills containers that outlive their grace period, which
+		// shows in the exit code
+		mode = StoppedGracefully
+		if force || resp.State.ExitCode == killedExitCode {
+			mode = StoppedKilled
+		}
+	}
+
+	var exit *ExitStatus
+	if resp.State != nil {
+		exit = NewExitStatus(resp.State.ExitCode, resp.State.OOMKilled, resp.State.Error)
+	}
+
 	log.WithField("container_id", id).Info("Removing container")
 
+	// only anonymous volumes are removed; named volumes outlive the task
 	err = d.Client.ContainerRemove(ctx, id, container.RemoveOptions{
 		RemoveVolumes: true,
 		RemoveLinks:   false,
@@ -199,13 +533,115 @@ func (d *Docker) Stop(id string) DockerResult {
 		return DockerResult{Error: err}
 	}
 
-	log.WithField("container_id", id).Info("Container stopped and removed successfully")
+	log.WithFields(map[string]interface{}{
+		"container_id": id,
+		"stop_mode":    mode,
+	}).Info("Container stopped and removed successfully")
 
 	return DockerResult{
 		ContainerId: id,
-		Action:      "stop",
+		Action:      action,
 		Result:      "success",
-		Error:       nil,
+		StopMode:    mode,
+		Exit:        exit,
+	}
+}
+
+// Update applies the CPU and memory limits and the restart policy of the
+// config to a running container. Limits can be changed but not removed,
+// since Docker reads zero as leaving a limit as it is.
+func (d *Docker) Update(id string) DockerResult {
+	resources := container.Resources{
+		Memory:   d.Config.Memory,
+		NanoCPUs: int64(d.Config.Cpu * math.Pow(10, 9)),
+	}
+	// containers get as much swap as memory unless told otherwise, and a
+	// memory limit above the swap limit is refused
+	if d.Config.Memory > 0 {
+		resources.MemorySwap = 2 * d.Config.Memory
+	}
+
+	policy := d.Config.RestartPolicy
+	if policy == "" {
+		policy = "no"
+	}
+
+	log.WithFields(map[string]interface{}{
+		"container_id":   id,
+		"cpu":            d.Config.Cpu,
+		"memory":         d.Config.Memory,
+		"restart_policy": policy,
+	}).Info("Updating container")
+
+	resp, err := d.Client.ContainerUpdate(context.Background(), id, container.UpdateConfig{
+		Resources:     resources,
+		RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyMode(policy)},
+	})
+	if err != nil {
+		log.WithField("container_id", id).Errorf("Failed to update container: %v", err)
+		return DockerResult{Error: err}
+	}
+
+	if len(resp.Warnings) > 0 {
+		log.WithFields(map[string]interface{}{
+			"container_id": id,
+			"warnings":     resp.Warnings,
+		}).Warn("Container updated with warnings")
+	}
+
+	return DockerResult{
+		ContainerId: id,
+		Action:      "update",
+		Result:      "success",
+	}
+}
+
+// Pause freezes every process of the container.
+func (d *Docker) Pause(id string) DockerResult {
+	log.WithField("container_id", id).Info("Pausing container")
+
+	if err := d.Client.ContainerPause(context.Background(), id); err != nil {
+		log.WithField("container_id", id).Errorf("Failed to pause container: %v", err)
+		return DockerResult{Error: err}
+	}
+
+	return DockerResult{
+		ContainerId: id,
+		Action:      "pause",
+		Result:      "success",
+	}
+}
+
+// Unpause lets the processes of a paused container run again.
+func (d *Docker) Unpause(id string) DockerResult {
+	log.WithField("container_id", id).Info("Unpausing container")
+
+	if err := d.Client.ContainerUnpause(context.Background(), id); err != nil {
+		log.WithField("container_id", id).Errorf("Failed to unpause container: %v", err)
+		return DockerResult{Error: err}
+	}
+
+	return DockerResult{
+		ContainerId: id,
+		Action:      "unpause",
+		Result:      "success",
+	}
+}
+
+// kill sends the container SIGKILL and waits for it to exit.
+func (d *Docker) kill(ctx context.Context, id string) error {
+	// waiting starts first so that the exit cannot be missed
+	waitCh, errCh := d.Client.ContainerWait(ctx, id, container.WaitConditionNotRunning)
+
+	if err := d.Client.ContainerKill(ctx, id, "SIGKILL"); err != nil {
+		return err
+	}
+
+	select {
+	case <-waitCh:
+		return nil
+	case err := <-errCh:
+		return err
 	}
 }
 
@@ -222,3 +658,122 @@ func (d *Docker) Inspect(containerID string) DockerInspectResponse {
 
 	return DockerInspectResponse{Container: &resp}
 }
+
+// Logs returns the container's output as a multiplexed stream, which can be
+// split into stdout and stderr with stdcopy.StdCopy. The stream is closed
+// when ctx is cancelled.
+func (d *Docker) Logs(ctx context.Context, containerID string, opts LogOptions) (io.ReadCloser, error) {
+	rc, err := d.Client.ContainerLogs(ctx, containerID, container.LogsOptions{
+		ShowStdout: opts.Stdout,
+		ShowStderr: opts.Stderr,
+		Follow:     opts.Follow,
+		Tail:       opts.Tail,
+		Since:      opts.Since,
+		Until:      opts.Until,
+		Timestamps: opts.Timestamps,
+	})
+	if err != nil {
+		log.WithField("container_id", containerID).Errorf("Failed to get container logs: %v", err)
+		return nil, err
+	}
+
+	return rc, nil
+}
+
+func (d *Docker) execCreate(ctx context.Context, containerID string, opts ExecOptions) (string, error) {
+	res, err := d.Client.ContainerExecCreate(ctx, containerID, container.ExecOptions{
+		Cmd:          opts.Cmd,
+		Env:          opts.Env,
+		User:         opts.User,
+		WorkingDir:   opts.WorkingDir,
+		Tty:          opts.Tty,
+		AttachStdin:  opts.Tty,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		log.WithField("container_id", containerID).Errorf("Failed to create exec: %v", err)
+		return "", err
+	}
+
+	return res.ID, nil
+}
+
+// Exec runs a command to completion inside the container and returns its
+// exit code along with everything it wrote to stdout and stderr.
+func (d *Docker) Exec(ctx context.Context, containerID string, opts ExecOptions) (ExecResult, error) {
+	opts.Tty = false
+
+	execID, err := d.execCreate(ctx, containerID, opts)
+	if err != nil {
+		return ExecResult{}, err
+	}
+
+	hijacked, err := d.Client.ContainerExecAttach(ctx, execID, container.ExecAttachOptions{})
+	if err != nil {
+		log.WithField("container_id", containerID).Errorf("Failed to attach to exec: %v", err)
+		return ExecResult{}, err
+	}
+	defer hijacked.Close()
+
+	var stdout, stderr bytes.Buffer
+	if _, err := stdcopy.StdCopy(&stdout, &stderr, hijacked.Reader); err != nil {
+		return ExecResult{}, err
+	}
+
+	exitCode, err := d.ExecExitCode(ctx, execID)
+	if err != nil {
+		return ExecResult{}, err
+	}
+
+	return ExecResult{
+		ExitCode: exitCode,
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+	}, nil
+}
+
+// ExecSession is a command started inside a container with its standard
+// streams attached. Without a TTY the output read from Reader is
+// multiplexed as with Logs.
+type ExecSession struct {
+	ID string
+	types.HijackedResponse
+	docker *Docker
+}
+
+func (d *Docker) ExecAttach(ctx context.Context, containerID string, opts ExecOptions) (*ExecSession, error) {
+	execID, err := d.execCreate(ctx, containerID, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	hijacked, err := d.Client.ContainerExecAttach(ctx, execID, container.ExecAttachOptions{Tty: opts.Tty})
+	if err != nil {
+		log.WithField("container_id", containerID).Errorf("Failed to attach to exec: %v", err)
+		return nil, err
+	}
+
+	return &ExecSession{ID: execID, HijackedResponse: hijacked, docker: d}, nil
+}
+
+func (s *ExecSession) Resize(ctx context.Context, height uint, width uint) error {
+	return s.docker.Client.ContainerExecResize(ctx, s.ID, container.ResizeOptions{
+		Height: height,
+		Width:  width,
+	})
+}
+
+func (s *ExecSession) ExitCode(ctx context.Context) (int, error) {
+	return s.docker.ExecExitCode(ctx, s.ID)
+}
+
+func (d *Docker) ExecExitCode(ctx context.Context, execID string) (int, error) {
+	res, err := d.Client.ContainerExecInspect(ctx, execID)
+	if err != nil {
+		log.WithField("exec_id", execID).Errorf("Failed to inspect exec: %v", err)
+		return 0, err
+	}
+
+	return res.ExitCode, nil
+}
@@ -0,0 +1,191 @@
+package task
+
+import (
+	"fmt"
+	"strings"
+)
+
+type SelectorOperator string
+
+const (
+	OpEquals       SelectorOperator = "="
+	OpNotEquals    SelectorOperator = "!="
+	OpIn           SelectorOperator = "in"
+	OpNotIn        SelectorOperator = "notin"
+	OpExists       SelectorOperator = "exists"
+	OpDoesNotExist SelectorOperator = "!"
+)
+
+// Requirement is a single condition on one label.
+type Requirement struct {
+	Key      string
+	Operator SelectorOperator
+	Values   []string
+}
+
+// Selector matches label sets that satisfy all of its requirements. The
+// zero value matches everything.
+type Selector []Requirement
+
+func (r Requirement) Matches(labels map[string]string) bool {
+	value, ok := labels[r.Key]
+
+	switch r.Operator {
+	case OpEquals, OpIn:
+		return ok && containsValue(r.Values, value)
+	case OpNotEquals, OpNotIn:
+		return !ok || !containsValue(r.Values, value)
+	case OpExists:
+		return ok
+	case OpDoesNotExist:
+		return !ok
+	}
+
+	return false
+}
+
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		if !r.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s Selector) Empty() bool {
+	return len(s) == 0
+}
+
+func containsValue(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// splitRequirements splits a selector on the commas that are not inside
+// the parentheses of a set.
+func splitRequirements(s string) ([]string, error) {
+	var parts []string
+	depth := 0
+	start := 0
+
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unbalanced parentheses in selector %q", s)
+			}
+		case ',':
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced parentheses in selector %q", s)
+	}
+
+	return append(parts, s[start:]), nil
+}
+
+func parseSet(s string) ([]string, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "(") || !strings.HasSuffix(s, ")") {
+		return nil, fmt.Errorf("expected a parenthesised set of values, got %q", s)
+	}
+
+	var values []string
+	for _, v := range strings.Split(s[1:len(s)-1], ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+
+	if len(values) == 0 {
+		return nil, fmt.Errorf("empty set of values %q", s)
+	}
+
+	return values, nil
+}
+
+func parseRequirement(s string) (Requirement, error) {
+	s = strings.TrimSpace(s)
+
+	if strings.HasPrefix(s, "!") {
+		key := strings.TrimSpace(s[1:])
+		if key == "" {
+			return Requirement{}, fmt.Errorf("missing label key in %q", s)
+		}
+		return Requirement{Key: key, Operator: OpDoesNotExist}, nil
+	}
+
+	if key, value, ok := strings.Cut(s, "!="); ok {
+		return Requirement{Key: strings.TrimSpace(key), Operator: OpNotEquals, Values: []string{strings.TrimSpace(value)}}, nil
+	}
+
+	if key, value, ok := strings.Cut(s, "=="); ok {
+		return Requirement{Key: strings.TrimSpace(key), Operator: OpEquals, Values: []string{strings.TrimSpace(value)}}, nil
+	}
+
+	if key, value, ok := strings.Cut(s, "="); ok {
+		return Requirement{Key: strings.TrimSpace(key), Operator: OpEquals, Values: []string{strings.TrimSpace(value)}}, nil
+	}
+
+	fields := strings.Fields(s)
+	if len(fields) == 1 && !strings.ContainsAny(s, "()") {
+		return Requirement{Key: fields[0], Operator: OpExists}, nil
+	}
+
+	if len(fields) >= 2 {
+		key := fields[0]
+		op := SelectorOperator(fields[1])
+		rest := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(s[len(key):]), fields[1]))
+
+		if op == OpIn || op == OpNotIn {
+			values, err := parseSet(rest)
+			if err != nil {
+				return Requirement{}, err
+			}
+			return Requirement{Key: key, Operator: op, Values: values}, nil
+		}
+	}
+
+	return Requirement{}, fmt.Errorf("invalid selector requirement %q", s)
+}
+
+// ParseSelector parses a comma separated list of label requirements:
+//
+//	app=web, tier!=cache, env in (prod,staging), zone notin (a), canary, !legacy
+func ParseSelector(s string) (Selector, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	parts, err := splitRequirements(s)
+	if err != nil {
+		return nil, err
+	}
+
+	selector := Selector{}
+	for _, part := range parts {
+		r, err := parseRequirement(part)
+		if err != nil {
+			return nil, err
+		}
+		if r.Key == "" {
+			return nil, fmt.Errorf("missing label key in %q", part)
+		}
+		selector = append(selector, r)
+	}
+
+	return selector, nil
+}
@@ -0,0 +1,333 @@
+package task
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/docker/go-connections/nat"
+	"github.com/google/uuid"
+)
+
+// Resource bounds of a single task. Memory and Disk are in bytes; Docker
+// refuses containers with less than 6MiB of memory.
+var MAX_TASK_CPU = 64.0
+var MIN_TASK_MEMORY int64 = 6 * 1024 * 1024
+var MAX_TASK_MEMORY int64 = 256 * 1024 * 1024 * 1024
+var MAX_TASK_DISK int64 = 1024 * 1024 * 1024 * 1024
+
+// Longest grace period and pre-stop hook timeout a task may ask for, in
+// seconds.
+var MAX_STOP_GRACE_PERIOD = 3600
+var MAX_PRE_STOP_TIMEOUT = 600
+
+var taskNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+var envNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// signalPattern matches the signal names, in any case, and numbers Docker
+// accepts, such as SIGTERM, TERM, SIGRTMIN+3 or 15
+var signalPattern = regexp.MustCompile(`(?i)^([0-9]+|(SIG)?[A-Z][A-Z0-9]*([+-][0-9]+)?)$`)
+
+var restartPolicies = map[string]bool{
+	"":               true,
+	"no":             true,
+	"always":         true,
+	"unless-stopped": true,
+	"on-failure":     true,
+}
+
+// TaskSpec is what clients submit to run a task. It holds only what the
+// caller decides; the state, container and placement of the task are the
+// manager's and workers' to fill in.
+type TaskSpec struct {
+	// ID is generated when empty
+	ID uuid.UUID
+	// Name is also the container's name, so it must be unique among the
+	// active tasks; it is generated from the service or image when empty
+	Name      string
+	Namespace string
+	Service   string
+	Image     string
+	Cpu       float64
+	Memory    int64
+	Disk      int64
+	// Ports to publish, in Docker's "[hostIP:][hostPort:]containerPort[/proto]"
+	// syntax; a port without a host port is published on a random one
+	Ports         []string
+	RestartPolicy string
+	Env           []string
+	Secrets       []SecretRef
+	Configs       []ConfigRef
+	Volumes       []VolumeMount
+	Networks      []string
+	Labels        map[string]string
+	Annotations   map[string]string
+	HealthCheck   string
+	// StopSignal, StopGracePeriod and PreStop say how the task is stopped;
+	// see Task
+	StopSignal      string
+	StopGracePeriod int
+	PreStop         *PreStopHook
+}
+
+// FieldError says what is wrong with one field of a submission.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// ValidationError lists every field of a submission that is invalid.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		messages = append(messages, fmt.Sprintf("%s: %s", f.Field, f.Message))
+	}
+	return "invalid task spec: " + strings.Join(messages, "; ")
+}
+
+// Add records an invalid field.
+func (e *ValidationError) Add(field string, format string, args ...interface{}) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Err returns e if any field is invalid, and nil otherwise.
+func (e *ValidationError) Err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Default fills in the fields the caller left out.
+func (s *TaskSpec) Default() {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	if s.Namespace == "" {
+		s.Namespace = DefaultNamespace
+	}
+	if s.Name == "" {
+		s.Name = fmt.Sprintf("%s-%s", s.baseName(), s.ID.String()[:8])
+	}
+}
+
+// baseName is what generated names start with: the service, or the last
+// path element of the image without its tag or digest.
+func (s *TaskSpec) baseName() string {
+	if s.Service != "" {
+		return s.Service
+	}
+
+	name := s.Image
+	if ref, err := reference.ParseNormalizedNamed(s.Image); err == nil {
+		name = reference.Path(ref)
+	}
+	name = path.Base(name)
+	if !taskNamePattern.MatchString(name) {
+		return "task"
+	}
+	return name
+}
+
+// Validate checks the fields that can be checked without the manager's
+// state. The manager checks namespaces, name uniqueness and references to
+// secrets, configs and volumes itself.
+func (s *TaskSpec) Validate() *ValidationError {
+	errs := &ValidationError{}
+
+	if s.Name != "" && !taskNamePattern.MatchString(s.Name) {
+		errs.Add("Name", "must start with a letter or digit and contain only letters, digits, '_', '.' and '-'")
+	}
+
+	if s.Image == "" {
+		errs.Add("Image", "is required")
+	} else if _, err := reference.ParseNormalizedNamed(s.Image); err != nil {
+		errs.Add("Image", "invalid image reference %q: %v", s.Image, err)
+	}
+
+	if s.Cpu < 0 || s.Cpu > MAX_TASK_CPU {
+		errs.Add("Cpu", "must be between 0 and %g", MAX_TASK_CPU)
+	}
+	if s.Memory != 0 && (s.Memory < MIN_TASK_MEMORY || s.Memory > MAX_TASK_MEMORY) {
+		errs.Add("Memory", "must be 0 or between %d and %d bytes", MIN_TASK_MEMORY, MAX_TASK_MEMORY)
+	}
+	if s.Disk < 0 || s.Disk > MAX_TASK_DISK {
+		errs.Add("Disk", "must be between 0 and %d bytes", MAX_TASK_DISK)
+	}
+
+	for i, p := range s.Ports {
+		if _, _, err := nat.ParsePortSpecs([]string{p}); err != nil {
+			errs.Add(fmt.Sprintf("Ports[%d]", i), "invalid port %q: %v", p, err)
+		}
+	}
+
+	if !restartPolicies[s.RestartPolicy] {
+		errs.Add("RestartPolicy", "must be one of no, always, unless-stopped or on-failure")
+	}
+
+	for i, e := range s.Env {
+		name, _, ok := strings.Cut(e, "=")
+		if !ok || !envNamePattern.MatchString(name) {
+			errs.Add(fmt.Sprintf("Env[%d]", i), "must be NAME=value with a valid variable name")
+		}
+	}
+
+	if s.HealthCheck != "" && !strings.HasPrefix(s.HealthCheck, "/") {
+		errs.Add("HealthCheck", "must be a path starting with /")
+	}
+	if s.HealthCheck != "" && !hasTCPPort(s.Ports) {
+		errs.Add("HealthCheck", "needs the task to expose a tcp port")
+	}
+
+	if s.StopSignal != "" && !signalPattern.MatchString(s.StopSignal) {
+		errs.Add("StopSignal", "invalid signal %q", s.StopSignal)
+	}
+	if s.StopGracePeriod < 0 || s.StopGracePeriod > MAX_STOP_GRACE_PERIOD {
+		errs.Add("StopGracePeriod", "must be between 0 and %d seconds", MAX_STOP_GRACE_PERIOD)
+	}
+
+	if h := s.PreStop; h != nil {
+		if (h.HTTPGet == "") == (len(h.Exec) == 0) {
+			errs.Add("PreStop", "must set exactly one of HTTPGet and Exec")
+		}
+		if h.HTTPGet != "" && !strings.HasPrefix(h.HTTPGet, "/") {
+			errs.Add("PreStop.HTTPGet", "must be a path starting with /")
+		}
+		if h.HTTPGet != "" && !hasTCPPort(s.Ports) {
+			errs.Add("PreStop.HTTPGet", "needs the task to expose a tcp port")
+		}
+		if h.Timeout < 0 || h.Timeout > MAX_PRE_STOP_TIMEOUT {
+			errs.Add("PreStop.Timeout", "must be between 0 and %d seconds", MAX_PRE_STOP_TIMEOUT)
+		}
+	}
+
+	return errs
+}
+
+func hasTCPPort(ports []string) bool {
+	exposed, _, err := nat.ParsePortSpecs(ports)
+	if err != nil {
+		return false
+	}
+	for p := range exposed {
+		if p.Proto() == "tcp" {
+			return true
+		}
+	}
+	return false
+}
+
+// Task turns the spec into a pending task.
+func (s *TaskSpec) Task() (Task, error) {
+	exposed, bindings, err := nat.ParsePortSpecs(s.Ports)
+	if err != nil {
+		return Task{}, err
+	}
+
+	return Task{
+		ID:            s.ID,
+		Name:          s.Name,
+		Namespace:     s.Namespace,
+		Service:       s.Service,
+		State:         Pending,
+		Image:         s.Image,
+		Cpu:           s.Cpu,
+		Memory:        s.Memory,
+		Disk:          s.Disk,
+		ExposedPorts:  exposed,
+		PortBindings:  bindings,
+		RestartPolicy: s.RestartPolicy,
+		Env:           s.Env,
+		Secrets:       s.Secrets,
+		Configs:       s.Configs,
+		Volumes:       s.Volumes,
+		Networks:      s.Networks,
+		Labels:        s.Labels,
+		Annotations:   s.Annotations,
+		HealthCheck:   s.HealthCheck,
+
+		StopSignal:      s.StopSignal,
+		StopGracePeriod: s.StopGracePeriod,
+		PreStop:         s.PreStop,
+	}, nil
+}
+
+// SpecOf returns the spec a task runs with. Ports are written out in a
+// canonical form, so that specs of the same task compare equal.
+func SpecOf(t *Task) TaskSpec {
+	return TaskSpec{
+		ID:              t.ID,
+		Name:            t.Name,
+		Namespace:       t.Namespace,
+		Service:         t.Service,
+		Image:           t.Image,
+		Cpu:             t.Cpu,
+		Memory:          t.Memory,
+		Disk:            t.Disk,
+		Ports:           portSpecs(t.ExposedPorts, t.PortBindings),
+		RestartPolicy:   t.RestartPolicy,
+		Env:             t.Env,
+		Secrets:         t.Secrets,
+		Configs:         t.Configs,
+		Volumes:         t.Volumes,
+		Networks:        t.Networks,
+		Labels:          t.Labels,
+		Annotations:     t.Annotations,
+		HealthCheck:     t.HealthCheck,
+		StopSignal:      t.StopSignal,
+		StopGracePeriod: t.StopGracePeriod,
+		PreStop:         t.PreStop,
+	}
+}
+
+// portSpecs turns exposed ports and their bindings back into sorted port
+// specs that nat.ParsePortSpecs reads as the same ports.
+func portSpecs(exposed nat.PortSet, bindings nat.PortMap) []string {
+	var specs []string
+
+	for p := range exposed {
+		if len(bindings[p]) == 0 {
+			specs = append(specs, string(p))
+			continue
+		}
+
+		for _, b := range bindings[p] {
+			switch {
+			case b.HostIP != "":
+				ip := b.HostIP
+				if strings.Contains(ip, ":") {
+					ip = "[" + ip + "]"
+				}
+				specs = append(specs, fmt.Sprintf("%s:%s:%s", ip, b.HostPort, p))
+			case b.HostPort != "":
+				specs = append(specs, fmt.Sprintf("%s:%s", b.HostPort, p))
+			default:
+				specs = append(specs, string(p))
+			}
+		}
+	}
+
+	sort.Strings(specs)
+	return specs
+}
+
+// NewTaskEvent returns the event that submits the task to be started.
+func NewTaskEvent(t Task, source EventSource, reason string) TaskEvent {
+	t.State = Scheduled
+	return TaskEvent{
+		ID:        uuid.New(),
+		State:     Scheduled,
+		Timestamp: time.Now().UTC(),
+		Task:      t,
+		Source:    source,
+		Reason:    reason,
+	}
+}
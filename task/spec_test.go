@@ -0,0 +1,195 @@
+package task
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func validSpec() TaskSpec {
+	return TaskSpec{
+		Name:  "web",
+		Image: "nginx:1.27",
+		Ports: []string{"8080:80"},
+	}
+}
+
+func TestTaskSpecValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(s *TaskSpec)
+		fields []string
+	}{
+		{name: "valid", change: func(s *TaskSpec) {}},
+		{name: "generated name", change: func(s *TaskSpec) { s.Name = "" }},
+		{name: "bad name", change: func(s *TaskSpec) { s.Name = "-web" }, fields: []string{"Name"}},
+		{name: "missing image", change: func(s *TaskSpec) { s.Image = "" }, fields: []string{"Image"}},
+		{name: "bad image", change: func(s *TaskSpec) { s.Image = "Nginx:latest" }, fields: []string{"Image"}},
+		{name: "negative cpu", change: func(s *TaskSpec) { s.Cpu = -1 }, fields: []string{"Cpu"}},
+		{name: "too much cpu", change: func(s *TaskSpec) { s.Cpu = MAX_TASK_CPU + 1 }, fields: []string{"Cpu"}},
+		{name: "too little memory", change: func(s *TaskSpec) { s.Memory = 1024 }, fields: []string{"Memory"}},
+		{name: "memory at minimum", change: func(s *TaskSpec) { s.Memory = MIN_TASK_MEMORY }},
+		{name: "negative disk", change: func(s *TaskSpec) { s.Disk = -1 }, fields: []string{"Disk"}},
+		{name: "bad port", change: func(s *TaskSpec) { s.Ports = []string{"80", "http"} }, fields: []string{"Ports[1]"}},
+		{name: "bad restart policy", change: func(s *TaskSpec) { s.RestartPolicy = "sometimes" }, fields: []string{"RestartPolicy"}},
+		{name: "bad env", change: func(s *TaskSpec) { s.Env = []string{"A=1", "NOVALUE", "1X=2"} }, fields: []string{"Env[1]", "Env[2]"}},
+		{name: "health check", change: func(s *TaskSpec) { s.HealthCheck = "/health" }},
+		{name: "relative health check", change: func(s *TaskSpec) { s.HealthCheck = "health" }, fields: []string{"HealthCheck"}},
+		{
+			name: "health check without tcp port",
+			change: func(s *TaskSpec) {
+				s.Ports = []string{"53/udp"}
+				s.HealthCheck = "/health"
+			},
+			fields: []string{"HealthCheck"},
+		},
+		{name: "signal name", change: func(s *TaskSpec) { s.StopSignal = "sigquit" }},
+		{name: "signal number", change: func(s *TaskSpec) { s.StopSignal = "15" }},
+		{name: "realtime signal", change: func(s *TaskSpec) { s.StopSignal = "SIGRTMIN+3" }},
+		{name: "bad signal", change: func(s *TaskSpec) { s.StopSignal = "SIG TERM" }, fields: []string{"StopSignal"}},
+		{name: "grace period too long", change: func(s *TaskSpec) { s.StopGracePeriod = MAX_STOP_GRACE_PERIOD + 1 }, fields: []string{"StopGracePeriod"}},
+		{name: "pre-stop http", change: func(s *TaskSpec) { s.PreStop = &PreStopHook{HTTPGet: "/drain"} }},
+		{name: "pre-stop exec", change: func(s *TaskSpec) { s.PreStop = &PreStopHook{Exec: []string{"drain"}, Timeout: 5} }},
+		{name: "pre-stop with both", change: func(s *TaskSpec) { s.PreStop = &PreStopHook{HTTPGet: "/drain", Exec: []string{"drain"}} }, fields: []string{"PreStop"}},
+		{name: "pre-stop with neither", change: func(s *TaskSpec) { s.PreStop = &PreStopHook{} }, fields: []string{"PreStop"}},
+		{name: "pre-stop relative path", change: func(s *TaskSpec) { s.PreStop = &PreStopHook{HTTPGet: "drain"} }, fields: []string{"PreStop.HTTPGet"}},
+		{
+			name: "pre-stop http without tcp port",
+			change: func(s *TaskSpec) {
+				s.Ports = []string{"53/udp"}
+				s.PreStop = &PreStopHook{HTTPGet: "/drain"}
+			},
+			fields: []string{"PreStop.HTTPGet"},
+		},
+		{
+			name: "pre-stop timeout too long",
+			change: func(s *TaskSpec) {
+				s.PreStop = &PreStopHook{Exec: []string{"drain"}, Timeout: MAX_PRE_STOP_TIMEOUT + 1}
+			},
+			fields: []string{"PreStop.Timeout"},
+		},
+		{
+			name: "every error is listed",
+			change: func(s *TaskSpec) {
+				s.Image = ""
+				s.Cpu = -1
+				s.HealthCheck = "health"
+			},
+			fields: []string{"Image", "Cpu", "HealthCheck"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := validSpec()
+			tt.change(&s)
+
+			var fields []string
+			for _, f := range s.Validate().Fields {
+				fields = append(fields, f.Field)
+			}
+
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("invalid fields = %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+}
+
+func TestValidationErrorErr(t *testing.T) {
+	errs := &ValidationError{}
+	if errs.Err() != nil {
+		t.Error("Err() of an empty ValidationError is not nil")
+	}
+
+	errs.Add("Cpu", "must be between 0 and %d", 64)
+	if errs.Err() == nil || !strings.Contains(errs.Error(), "Cpu: must be between 0 and 64") {
+		t.Errorf("Err() = %v", errs.Err())
+	}
+}
+
+func TestTaskSpecDefault(t *testing.T) {
+	tests := []struct {
+		name       string
+		spec       TaskSpec
+		namePrefix string
+	}{
+		{name: "from image", spec: TaskSpec{Image: "nginx:1.27"}, namePrefix: "nginx-"},
+		{name: "from image with registry and digest", spec: TaskSpec{Image: "registry.example.com:5000/team/api@sha256:" + strings.Repeat("a", 64)}, namePrefix: "api-"},
+		{name: "from service", spec: TaskSpec{Image: "nginx", Service: "frontend"}, namePrefix: "frontend-"},
+		{name: "unparseable image", spec: TaskSpec{Image: "Not An Image"}, namePrefix: "task-"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.spec
+			s.Default()
+
+			if s.ID == uuid.Nil {
+				t.Error("ID was not generated")
+			}
+			if s.Namespace != DefaultNamespace {
+				t.Errorf("Namespace = %q, want %q", s.Namespace, DefaultNamespace)
+			}
+			if want := tt.namePrefix + s.ID.String()[:8]; s.Name != want {
+				t.Errorf("Name = %q, want %q", s.Name, want)
+			}
+		})
+	}
+}
+
+func TestTaskSpecDefaultKeepsGivenFields(t *testing.T) {
+	id := uuid.New()
+	s := TaskSpec{ID: id, Name: "web", Namespace: "team-a", Image: "nginx"}
+	s.Default()
+
+	if s.ID != id || s.Name != "web" || s.Namespace != "team-a" {
+		t.Errorf("Default changed given fields: %+v", s)
+	}
+}
+
+func TestSpecOfPortsRoundTrip(t *testing.T) {
+	tests := []struct {
+		ports []string
+		want  []string
+	}{
+		{ports: nil, want: nil},
+		{ports: []string{"80"}, want: []string{"80/tcp"}},
+		{ports: []string{"8080:80", "53/udp"}, want: []string{"53/udp", "8080:80/tcp"}},
+		{ports: []string{"127.0.0.1:8080:80/tcp"}, want: []string{"127.0.0.1:8080:80/tcp"}},
+		{ports: []string{"[::1]:8443:443"}, want: []string{"[::1]:8443:443/tcp"}},
+		{ports: []string{"9000:80", "9001:80"}, want: []string{"9000:80/tcp", "9001:80/tcp"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.ports, ","), func(t *testing.T) {
+			s := validSpec()
+			s.Ports = tt.ports
+
+			first, err := s.Task()
+			if err != nil {
+				t.Fatalf("Task(): %v", err)
+			}
+
+			ports := SpecOf(&first).Ports
+			if !reflect.DeepEqual(ports, tt.want) {
+				t.Fatalf("SpecOf().Ports = %q, want %q", ports, tt.want)
+			}
+
+			// the canonical specs describe the same ports
+			again := SpecOf(&first)
+			second, err := again.Task()
+			if err != nil {
+				t.Fatalf("Task() from canonical ports: %v", err)
+			}
+			if !reflect.DeepEqual(first.ExposedPorts, second.ExposedPorts) || !reflect.DeepEqual(first.PortBindings, second.PortBindings) {
+				t.Errorf("ports %q read back as %v %v, want %v %v", ports, second.ExposedPorts, second.PortBindings, first.ExposedPorts, first.PortBindings)
+			}
+			if back := SpecOf(&second).Ports; !reflect.DeepEqual(back, ports) {
+				t.Errorf("canonical ports changed on a second round trip: %q, want %q", back, ports)
+			}
+		})
+	}
+}
@@ -1,11 +1,85 @@
 package task
 
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 var stateTransitionMap = map[State][]State{
-	Pending:   {Scheduled},
-	Scheduled: {Scheduled, Running, Failed},
-	Running:   {Running, Completed, Failed},
-	Completed: {},
-	Failed:    {},
+	Pending:    {Scheduled},
+	Scheduled:  {Scheduled, Running, Failed, Lost, Preempted},
+	Running:    {Running, Completed, Failed, Stopping, Restarting, Lost, Preempted, Paused},
+	Completed:  {},
+	Failed:     {Restarting},
+	Stopping:   {Completed, Failed},
+	Restarting: {Restarting, Running, Failed},
+	// a lost task is in whatever state its worker reports once it is
+	// reachable again
+	Lost:      {Scheduled, Running, Completed, Failed, Stopping, Restarting, Paused},
+	Preempted: {},
+	Paused:    {Running, Completed, Failed, Stopping, Restarting, Lost},
+}
+
+var stateNames = []string{
+	Pending:    "pending",
+	Scheduled:  "scheduled",
+	Running:    "running",
+	Completed:  "completed",
+	Failed:     "failed",
+	Stopping:   "stopping",
+	Restarting: "restarting",
+	Lost:       "lost",
+	Preempted:  "preempted",
+	Paused:     "paused",
+}
+
+func (s State) String() string {
+	if s < 0 || int(s) >= len(stateNames) {
+		return strconv.Itoa(int(s))
+	}
+	return stateNames[s]
+}
+
+// ParseState accepts a state's name, in any case, or its number.
+func ParseState(value string) (State, error) {
+	for i, name := range stateNames {
+		if strings.EqualFold(value, name) {
+			return State(i), nil
+		}
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 || n >= len(stateNames) {
+		return 0, fmt.Errorf("invalid state: %q", value)
+	}
+	return State(n), nil
+}
+
+// MarshalJSON writes states by name.
+func (s State) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// UnmarshalJSON reads states by name, and also by number as they were
+// written before they had names.
+func (s *State) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		var n int
+		if err := json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("invalid state: %s", data)
+		}
+		value = strconv.Itoa(n)
+	}
+
+	state, err := ParseState(value)
+	if err != nil {
+		return err
+	}
+	*s = state
+	return nil
 }
 
 func Contains(states []State, state State) bool {
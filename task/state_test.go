@@ -0,0 +1,104 @@
+package task
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseState(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    State
+		wantErr bool
+	}{
+		{value: "pending", want: Pending},
+		{value: "Running", want: Running},
+		{value: "PAUSED", want: Paused},
+		{value: "0", want: Pending},
+		{value: "2", want: Running},
+		{value: "9", want: Paused},
+		{value: "10", wantErr: true},
+		{value: "-1", wantErr: true},
+		{value: "", wantErr: true},
+		{value: "runnin", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseState(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseState(%q) = %v, want an error", tt.value, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("ParseState(%q) = %v, %v; want %v", tt.value, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestStateUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		data    string
+		want    State
+		wantErr bool
+	}{
+		{data: `"running"`, want: Running},
+		{data: `"Completed"`, want: Completed},
+		{data: `4`, want: Failed},
+		{data: `0`, want: Pending},
+		{data: `10`, wantErr: true},
+		{data: `-1`, wantErr: true},
+		{data: `"10"`, wantErr: true},
+		{data: `"unknown"`, wantErr: true},
+		{data: `1.5`, wantErr: true},
+		{data: `null`, wantErr: true},
+		{data: `true`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.data, func(t *testing.T) {
+			var got State
+			err := json.Unmarshal([]byte(tt.data), &got)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("unmarshalling %s = %v, want an error", tt.data, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("unmarshalling %s = %v, %v; want %v", tt.data, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestStateJSONRoundTrip(t *testing.T) {
+	for i := range stateNames {
+		s := State(i)
+
+		data, err := json.Marshal(s)
+		if err != nil {
+			t.Fatalf("marshalling %v: %v", s, err)
+		}
+		if want := `"` + stateNames[i] + `"`; string(data) != want {
+			t.Errorf("marshalled %d as %s, want %s", i, data, want)
+		}
+
+		var got State
+		if err := json.Unmarshal(data, &got); err != nil || got != s {
+			t.Errorf("round trip of %v = %v, %v", s, got, err)
+		}
+	}
+}
+
+func TestStateString(t *testing.T) {
+	if got := Restarting.String(); got != "restarting" {
+		t.Errorf("Restarting.String() = %q", got)
+	}
+	if got := State(42).String(); got != "42" {
+		t.Errorf("State(42).String() = %q, want \"42\"", got)
+	}
+}
@@ -1,6 +1,8 @@
 package task
 
 import (
+	"fmt"
+	"os"
 	"time"
 
 	"github.com/docker/go-connections/nat"
@@ -15,13 +17,32 @@ const (
 	Running
 	Completed
 	Failed
+	// Stopping tasks have been asked to stop and are shutting down
+	Stopping
+	// Restarting tasks are getting a new container on the same worker
+	Restarting
+	// Lost tasks are on a worker the manager cannot reach
+	Lost
+	// Preempted tasks were stopped to make room for others
+	Preempted
+	// Paused tasks keep their container, with its processes frozen
+	Paused
 )
 
+// DefaultNamespace holds tasks submitted without a namespace.
+const DefaultNamespace = "default"
+
 type Task struct {
-	ID            uuid.UUID
-	ContainerID   string
-	Name          string
-	State         State
+	ID          uuid.UUID
+	ContainerID string
+	Name        string
+	Namespace   string
+	// Service groups the tasks that serve the same purpose, and is the name
+	// they are reached by on their networks
+	Service string
+	State   State
+	// StateReason explains how the task got to its current state
+	StateReason   string
 	Image         string
 	Cpu           float64
 	Memory        int64
@@ -32,14 +53,224 @@ type Task struct {
 	StartTime     time.Time
 	FinishTime    time.Time
 	HostPorts     nat.PortMap
+	Env           []string
+
+	// Secrets are resolved by the manager and handed to the worker only
+	// when it starts the task; their values are never part of the task
+	Secrets []SecretRef
+	Configs []ConfigRef
+	Volumes []VolumeMount
+	// Networks of the task's namespace to join, such as one named after
+	// the namespace or the service; the task stays on Docker's default
+	// bridge when there are none
+	Networks []string
+
+	// Labels identify the task and are matched by selectors, while
+	// annotations hold arbitrary non-identifying metadata
+	Labels      map[string]string
+	Annotations map[string]string
 
-	HealthCheck  string
+	HealthCheck string
+	Health      Health
+	// RestartCount counts every restart of the task, whatever caused it
 	RestartCount int
+	// FailureRestarts counts the restarts the manager made because the
+	// task failed or was unhealthy; MAX_RESTART_COUNT limits only these
+	FailureRestarts int
+	// Revision of the task's spec, which starts at 1 and goes up with
+	// every update
+	Revision int
+	// Exit is how the task's container ended; nil until it has
+	Exit *ExitStatus
+
+	// StopSignal is sent to the container to stop it; empty means the
+	// image's own stop signal, which is SIGTERM unless it sets one
+	StopSignal string
+	// StopGracePeriod is how many seconds the container has to exit after
+	// its stop signal before it is killed; 0 means DEFAULT_STOP_GRACE_PERIOD
+	StopGracePeriod int
+	// PreStop runs before the stop signal is sent
+	PreStop *PreStopHook
+	// StopMode says how the container was stopped on request; empty while
+	// it has not been
+	StopMode StopMode
+}
+
+// DEFAULT_STOP_GRACE_PERIOD is the grace period of tasks that set none,
+// and the same as Docker's, in seconds.
+var DEFAULT_STOP_GRACE_PERIOD = 10
+
+// GracePeriod is how long the task's container has to exit after its stop
+// signal.
+func (t *Task) GracePeriod() time.Duration {
+	if t.StopGracePeriod > 0 {
+		return time.Duration(t.StopGracePeriod) * time.Second
+	}
+	return time.Duration(DEFAULT_STOP_GRACE_PERIOD) * time.Second
+}
+
+// PreStopHook runs before a task is sent its stop signal, so that it can
+// drain connections or save its state. Exactly one of HTTPGet and Exec is
+// set. The task is stopped whether or not the hook succeeds.
+type PreStopHook struct {
+	// HTTPGet is a path requested on the task's first tcp port
+	HTTPGet string
+	// Exec is a command run inside the container
+	Exec []string
+	// Timeout in seconds; 0 means DEFAULT_PRE_STOP_TIMEOUT
+	Timeout int
+}
+
+// DEFAULT_PRE_STOP_TIMEOUT bounds pre-stop hooks that set no timeout, in
+// seconds.
+var DEFAULT_PRE_STOP_TIMEOUT = 30
+
+func (h *PreStopHook) TimeoutDuration() time.Duration {
+	if h.Timeout > 0 {
+		return time.Duration(h.Timeout) * time.Second
+	}
+	return time.Duration(DEFAULT_PRE_STOP_TIMEOUT) * time.Second
+}
+
+// StopMode is how a container was stopped.
+type StopMode string
+
+const (
+	// StoppedGracefully containers exited within their grace period
+	StoppedGracefully StopMode = "graceful"
+	// StoppedKilled containers were sent SIGKILL, because they outlived
+	// their grace period or were force-killed
+	StoppedKilled StopMode = "killed"
+)
+
+// ExitStatus is how a task's container ended, as reported by the runtime.
+type ExitStatus struct {
+	ExitCode  int
+	OOMKilled bool
+	// Error is the runtime's error, such as why the container could not
+	// be started
+	Error string
+	// Success is true when the container exited on its own with code 0
+	Success bool
+}
+
+// NewExitStatus classifies how a container ended. Only a zero exit code is
+// a success; an OOM kill or a runtime error is a failure whatever the code.
+func NewExitStatus(exitCode int, oomKilled bool, err string) *ExitStatus {
+	return &ExitStatus{
+		ExitCode:  exitCode,
+		OOMKilled: oomKilled,
+		Error:     err,
+		Success:   exitCode == 0 && !oomKilled && err == "",
+	}
+}
+
+// Reason describes the exit for a task's state change.
+func (e *ExitStatus) Reason() string {
+	reason := fmt.Sprintf("Container exited with code %d", e.ExitCode)
+	if e.OOMKilled {
+		reason += ", killed for running out of memory"
+	}
+	if e.Error != "" {
+		reason += ": " + e.Error
+	}
+	return reason
 }
 
+// SecretRef injects a secret from the task's namespace into its container,
+// as the environment variable Env, the file at path File, or both.
+type SecretRef struct {
+	Name string
+	Env  string
+	File string
+	// Mode of the file; defaults to 0444
+	Mode os.FileMode
+}
+
+// ConfigRef mounts a version of a config from the task's namespace into its
+// container as a read-only file at path File.
+type ConfigRef struct {
+	Name string
+	// Version to mount; 0 means the latest version when the task is
+	// submitted, which is then pinned on the task
+	Version int
+	File    string
+	// Mode of the file; defaults to 0444
+	Mode os.FileMode
+}
+
+type VolumeType string
+
+const (
+	// VolumeBind mounts a host path, which the worker must allow
+	VolumeBind VolumeType = "bind"
+	// VolumeNamed mounts a named volume of the task's namespace. Named
+	// volumes live on one worker and outlive the tasks using them
+	VolumeNamed VolumeType = "volume"
+	// VolumeTmpfs mounts an empty in-memory filesystem
+	VolumeTmpfs VolumeType = "tmpfs"
+)
+
+// VolumeMount mounts storage into the task's container at Target.
+type VolumeMount struct {
+	Type VolumeType
+	// Source is the host path of a bind mount or the name of a named
+	// volume; tmpfs mounts have none
+	Source   string
+	Target   string
+	ReadOnly bool
+	// Size limits a tmpfs mount, in bytes
+	Size int64
+}
+
+type HealthStatus string
+
+const (
+	HealthUnknown HealthStatus = ""
+	Healthy       HealthStatus = "healthy"
+	Unhealthy     HealthStatus = "unhealthy"
+)
+
+// Health is the result of the most recent health probe, as run and reported
+// by the worker that owns the task.
+type Health struct {
+	Status HealthStatus
+	// Output of the last probe, or the error that made it fail
+	Message string
+	// Number of probes that failed in a row
+	Failures  int
+	CheckedAt time.Time
+}
+
+// EventSource says what caused a task event.
+type EventSource string
+
+const (
+	SourceAPI         EventSource = "api"
+	SourceHealthCheck EventSource = "health_check"
+	SourceScheduler   EventSource = "scheduler"
+	SourceWorker      EventSource = "worker"
+	// SourceRestartPolicy is the manager restarting a task that failed
+	SourceRestartPolicy EventSource = "restart_policy"
+	// SourceNode is the manager noticing a worker become unreachable
+	SourceNode EventSource = "node"
+)
+
 type TaskEvent struct {
 	ID        uuid.UUID
 	State     State
 	Timestamp time.Time
 	Task      Task
+	Source    EventSource
+	// Reason is a human readable explanation of the change
+	Reason string
+}
+
+// WorkRequest is what the manager sends to a worker to act on a task event.
+// Secrets and Configs hold the contents of the task's secrets and configs,
+// keyed by name, and are only set for events that start the task.
+type WorkRequest struct {
+	TaskEvent
+	Secrets map[string][]byte
+	Configs map[string][]byte
 }
@@ -0,0 +1,174 @@
+package httputil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotentReplayHeader is set on responses replayed for a repeated key.
+const IdempotentReplayHeader = "Idempotent-Replayed"
+
+// IDEMPOTENCY_KEY_TTL is how long a key's response is kept for replay.
+var IDEMPOTENCY_KEY_TTL = 24 * time.Hour
+
+// MAX_IDEMPOTENT_BODY_SIZE bounds the body of a request with an
+// Idempotency-Key, as it is read into memory to fingerprint it.
+var MAX_IDEMPOTENT_BODY_SIZE int64 = 10 * 1024 * 1024
+
+type idempotentResponse struct {
+	fingerprint [sha256.Size]byte
+	done        bool
+	status      int
+	contentType string
+	body        []byte
+	createdAt   time.Time
+}
+
+// IdempotencyStore remembers the responses to requests that carried an
+// Idempotency-Key header, so that a retried request is answered with the
+// original response instead of being carried out again.
+type IdempotencyStore struct {
+	mu        sync.Mutex
+	responses map[string]*idempotentResponse
+}
+
+func NewIdempotencyStore() *IdempotencyStore {
+	return &IdempotencyStore{responses: make(map[string]*idempotentResponse)}
+}
+
+// Middleware runs requests with an Idempotency-Key once per key. A repeated
+// request gets the recorded response; one still in progress gets 409, and
+// one with a different body than the original gets 422, and one with a body
+// over MAX_IDEMPOTENT_BODY_SIZE gets 413. Server errors and panics are not
+// recorded, so the request can be retried. Keys are kept apart per
+// scope, such as the caller's identity. Requests without the header pass
+// through.
+func (s *IdempotencyStore) Middleware(scope func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// one byte over the limit tells a body that is too large from
+			// one that is exactly at it
+			body, err := io.ReadAll(io.LimitReader(r.Body, MAX_IDEMPOTENT_BODY_SIZE+1))
+			if err != nil {
+				WriteError(w, http.StatusBadRequest, fmt.Sprintf("error reading body: %v", err))
+				return
+			}
+			if int64(len(body)) > MAX_IDEMPOTENT_BODY_SIZE {
+				WriteError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body is larger than %d bytes", MAX_IDEMPOTENT_BODY_SIZE))
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			fingerprint := sha256.Sum256(append([]byte(r.Method+" "+r.URL.Path+"\n"), body...))
+			key = scope(r) + "\x00" + key
+
+			recorded, ok := s.begin(key, fingerprint)
+			if ok {
+				switch {
+				case recorded.fingerprint != fingerprint:
+					WriteError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+				case !recorded.done:
+					WriteError(w, http.StatusConflict, "A request with this Idempotency-Key is still in progress")
+				default:
+					if recorded.contentType != "" {
+						w.Header().Set("Content-Type", recorded.contentType)
+					}
+					w.Header().Set(IdempotentReplayHeader, "true")
+					w.WriteHeader(recorded.status)
+					w.Write(recorded.body)
+				}
+				return
+			}
+
+			// a handler that panics must not leave the key in progress
+			finished := false
+			defer func() {
+				if !finished {
+					s.release(key)
+				}
+			}()
+
+			rec := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+			s.finish(key, rec)
+			finished = true
+		})
+	}
+}
+
+// begin returns the recorded response for key, or reserves the key for a
+// new request.
+func (s *IdempotencyStore) begin(key string, fingerprint [sha256.Size]byte) (idempotentResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, resp := range s.responses {
+		if resp.done && now.Sub(resp.createdAt) > IDEMPOTENCY_KEY_TTL {
+			delete(s.responses, k)
+		}
+	}
+
+	if resp, ok := s.responses[key]; ok {
+		return *resp, true
+	}
+
+	s.responses[key] = &idempotentResponse{fingerprint: fingerprint, createdAt: now}
+	return idempotentResponse{}, false
+}
+
+// release forgets a key whose request did not complete, so that it can be
+// retried.
+func (s *IdempotencyStore) release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.responses, key)
+}
+
+func (s *IdempotencyStore) finish(key string, rec *recordingWriter) {
+	if rec.status >= http.StatusInternalServerError {
+		s.release(key)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resp := s.responses[key]
+	resp.done = true
+	resp.status = rec.status
+	resp.contentType = rec.Header().Get("Content-Type")
+	resp.body = rec.body.Bytes()
+	resp.createdAt = time.Now()
+}
+
+// recordingWriter copies a response as it is written.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
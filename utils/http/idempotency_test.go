@@ -0,0 +1,172 @@
+package httputil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// countingHandler responds with status and counts how often it ran.
+func countingHandler(status int, calls *int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		WriteJSON(w, status, map[string]int{"Call": *calls})
+	})
+}
+
+func scopeByHeader(r *http.Request) string {
+	return r.Header.Get("X-Caller")
+}
+
+func idempotentRequest(handler http.Handler, key string, caller string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/tasks", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	req.Header.Set("X-Caller", caller)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestIdempotencyReplaysResponse(t *testing.T) {
+	calls := 0
+	handler := NewIdempotencyStore().Middleware(scopeByHeader)(countingHandler(http.StatusCreated, &calls))
+
+	first := idempotentRequest(handler, "k1", "alice", `{"Name":"a"}`)
+	second := idempotentRequest(handler, "k1", "alice", `{"Name":"a"}`)
+
+	if calls != 1 {
+		t.Fatalf("handler ran %d times, want 1", calls)
+	}
+	if second.Code != http.StatusCreated || second.Body.String() != first.Body.String() {
+		t.Errorf("replay = %d %q, want %d %q", second.Code, second.Body.String(), first.Code, first.Body.String())
+	}
+	if second.Header().Get(IdempotentReplayHeader) != "true" {
+		t.Errorf("replay is missing the %s header", IdempotentReplayHeader)
+	}
+	if first.Header().Get(IdempotentReplayHeader) != "" {
+		t.Errorf("original response has the %s header", IdempotentReplayHeader)
+	}
+}
+
+func TestIdempotencyPassesThroughWithoutKey(t *testing.T) {
+	calls := 0
+	handler := NewIdempotencyStore().Middleware(scopeByHeader)(countingHandler(http.StatusCreated, &calls))
+
+	idempotentRequest(handler, "", "alice", `{}`)
+	idempotentRequest(handler, "", "alice", `{}`)
+
+	if calls != 2 {
+		t.Errorf("handler ran %d times, want 2", calls)
+	}
+}
+
+func TestIdempotencyRejectsDifferentRequest(t *testing.T) {
+	calls := 0
+	handler := NewIdempotencyStore().Middleware(scopeByHeader)(countingHandler(http.StatusCreated, &calls))
+
+	idempotentRequest(handler, "k1", "alice", `{"Name":"a"}`)
+	rec := idempotentRequest(handler, "k1", "alice", `{"Name":"b"}`)
+
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+	if calls != 1 {
+		t.Errorf("handler ran %d times, want 1", calls)
+	}
+}
+
+func TestIdempotencyKeepsScopesApart(t *testing.T) {
+	calls := 0
+	handler := NewIdempotencyStore().Middleware(scopeByHeader)(countingHandler(http.StatusCreated, &calls))
+
+	idempotentRequest(handler, "k1", "alice", `{}`)
+	rec := idempotentRequest(handler, "k1", "bob", `{}`)
+
+	if calls != 2 || rec.Header().Get(IdempotentReplayHeader) != "" {
+		t.Errorf("a key of one caller was replayed to another")
+	}
+}
+
+func TestIdempotencyConflictWhileInProgress(t *testing.T) {
+	store := NewIdempotencyStore()
+	var inner *httptest.ResponseRecorder
+
+	var handler http.Handler
+	handler = store.Middleware(scopeByHeader)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the same key arrives again before this request is done
+		if inner == nil {
+			inner = idempotentRequest(handler, "k1", "alice", `{}`)
+		}
+		WriteNoContent(w)
+	}))
+
+	idempotentRequest(handler, "k1", "alice", `{}`)
+
+	if inner.Code != http.StatusConflict {
+		t.Errorf("status = %d, want %d", inner.Code, http.StatusConflict)
+	}
+}
+
+func TestIdempotencyDoesNotRecordServerErrors(t *testing.T) {
+	calls := 0
+	handler := NewIdempotencyStore().Middleware(scopeByHeader)(countingHandler(http.StatusInternalServerError, &calls))
+
+	idempotentRequest(handler, "k1", "alice", `{}`)
+	idempotentRequest(handler, "k1", "alice", `{}`)
+
+	if calls != 2 {
+		t.Errorf("handler ran %d times, want 2", calls)
+	}
+}
+
+func TestIdempotencyReleasesKeyAfterPanic(t *testing.T) {
+	calls := 0
+	handler := NewIdempotencyStore().Middleware(scopeByHeader)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			panic("handler failed")
+		}
+		WriteNoContent(w)
+	}))
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("panic was swallowed by the middleware")
+			}
+		}()
+		idempotentRequest(handler, "k1", "alice", `{}`)
+	}()
+
+	rec := idempotentRequest(handler, "k1", "alice", `{}`)
+	if rec.Code != http.StatusNoContent || calls != 2 {
+		t.Errorf("retry after panic = %d after %d calls, want %d after 2", rec.Code, calls, http.StatusNoContent)
+	}
+}
+
+func TestIdempotencyRejectsOversizedBody(t *testing.T) {
+	defer func(size int64) { MAX_IDEMPOTENT_BODY_SIZE = size }(MAX_IDEMPOTENT_BODY_SIZE)
+	MAX_IDEMPOTENT_BODY_SIZE = 8
+
+	calls := 0
+	handler := NewIdempotencyStore().Middleware(scopeByHeader)(countingHandler(http.StatusCreated, &calls))
+
+	tests := []struct {
+		body string
+		want int
+	}{
+		{body: "12345678", want: http.StatusCreated},
+		{body: "123456789", want: http.StatusRequestEntityTooLarge},
+	}
+
+	for i, tt := range tests {
+		rec := idempotentRequest(handler, string(rune('a'+i)), "alice", tt.body)
+		if rec.Code != tt.want {
+			t.Errorf("body of %d bytes: status = %d, want %d", len(tt.body), rec.Code, tt.want)
+		}
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -43,3 +45,33 @@ func GetUUIDParam(r *http.Request, key string) (uuid.UUID, error) {
 
 	return parsedUUID, nil
 }
+
+// GetBoolQuery parses an optional boolean query parameter. A parameter given
+// without a value, as in ?follow, counts as true.
+func GetBoolQuery(r *http.Request, key string, def bool) (bool, error) {
+	values, ok := r.URL.Query()[key]
+	if !ok {
+		return def, nil
+	}
+
+	if len(values) == 0 || values[0] == "" {
+		return true, nil
+	}
+
+	b, err := strconv.ParseBool(values[0])
+	if err != nil {
+		return def, fmt.Errorf("invalid boolean for query parameter %s: %q", key, values[0])
+	}
+
+	return b, nil
+}
+
+// BearerToken returns the token from an "Authorization: Bearer" header, or
+// an empty string when there is none.
+func BearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
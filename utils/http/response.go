@@ -35,6 +35,21 @@ func WriteError(w http.ResponseWriter, code int, message string) {
 	_ = WriteJSON(w, code, response)
 }
 
+// WriteFieldErrors writes an error response that lists the invalid fields
+// of the request.
+func WriteFieldErrors(w http.ResponseWriter, code int, message string, errs []FieldError) {
+	log.WithFields(map[string]interface{}{
+		"status_code": code,
+		"fields":      len(errs),
+	}).Warn(message)
+
+	_ = WriteJSON(w, code, ErrorResponse{
+		HTTPStatusCode: code,
+		Message:        message,
+		Errors:         errs,
+	})
+}
+
 func WriteNoContent(w http.ResponseWriter) {
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,125 @@
+package httputil
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// FlushWriter flushes the response after every write, so streamed output
+// reaches the client as soon as it is produced.
+type FlushWriter struct {
+	w io.Writer
+	f http.Flusher
+}
+
+func NewFlushWriter(w http.ResponseWriter) *FlushWriter {
+	f, _ := w.(http.Flusher)
+	return &FlushWriter{w: w, f: f}
+}
+
+func (fw *FlushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	if fw.f != nil {
+		fw.f.Flush()
+	}
+	return n, err
+}
+
+// WantsSSE reports whether the client asked for a Server-Sent Events stream,
+// either through the Accept header or with ?format=sse.
+func WantsSSE(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "sse" {
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+func WriteStreamHeader(w http.ResponseWriter, contentType string) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func WriteSSEHeader(w http.ResponseWriter) {
+	w.Header().Set("Connection", "keep-alive")
+	WriteStreamHeader(w, "text/event-stream")
+}
+
+// WriteSSE writes a single Server-Sent Event and flushes it. Empty id and
+// event fields are left out.
+func WriteSSE(w io.Writer, id string, event string, data string) error {
+	var b bytes.Buffer
+
+	if id != "" {
+		fmt.Fprintf(&b, "id: %s\n", id)
+	}
+	if event != "" {
+		fmt.Fprintf(&b, "event: %s\n", event)
+	}
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteByte('\n')
+
+	_, err := w.Write(b.Bytes())
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return err
+}
+
+// SSELineWriter turns a byte stream into one Server-Sent Event per line.
+// Call Close once the stream ends to send a trailing partial line.
+type SSELineWriter struct {
+	W     io.Writer
+	Event string
+	buf   []byte
+}
+
+func (s *SSELineWriter) Write(p []byte) (int, error) {
+	s.buf = append(s.buf, p...)
+
+	for {
+		i := bytes.IndexByte(s.buf, '\n')
+		if i < 0 {
+			break
+		}
+
+		line := strings.TrimSuffix(string(s.buf[:i]), "\r")
+		s.buf = s.buf[i+1:]
+
+		if err := WriteSSE(s.W, "", s.Event, line); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+func (s *SSELineWriter) Close() error {
+	if len(s.buf) == 0 {
+		return nil
+	}
+
+	line := string(s.buf)
+	s.buf = nil
+	return WriteSSE(s.W, "", s.Event, line)
+}
+
+// WriteSSEComment writes a comment line, which clients ignore. It is used to
+// keep idle connections from being closed by proxies.
+func WriteSSEComment(w io.Writer, text string) error {
+	_, err := fmt.Fprintf(w, ": %s\n\n", text)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return err
+}
@@ -3,4 +3,11 @@ package httputil
 type ErrorResponse struct {
 	HTTPStatusCode int    `json:"http_status_code"`
 	Message        string `json:"message"`
+	// Errors lists the invalid fields of a rejected request
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
 }
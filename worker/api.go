@@ -1,6 +1,9 @@
 package worker
 
 import (
+	"Mine-Cube/auth"
+	httputil "Mine-Cube/utils/http"
+	"crypto/tls"
 	"fmt"
 	"net/http"
 
@@ -13,29 +16,74 @@ type Api struct {
 	Port    int
 	Worker  *Worker
 	Router  *chi.Mux
+	// Auth checks every request's bearer token and permissions. All
+	// requests are allowed when it is nil.
+	Auth *auth.Authorizer
+	// TLSConfig serves the API over TLS when set
+	TLSConfig *tls.Config
+
+	idempotency *httputil.IdempotencyStore
 }
 
 func (a *Api) SetupRoutes() {
 	a.Router = chi.NewRouter()
 
 	a.Router.Use(middleware.Logger)
+	a.Router.Use(a.Auth.Authenticate)
+
+	can := a.Auth.Require
+
+	a.idempotency = httputil.NewIdempotencyStore()
+	idempotent := a.idempotency.Middleware(auth.PrincipalName)
 
 	a.Router.Route("/tasks", func(r chi.Router) {
-		r.Post("/", a.StartTaskHandler)
+		r.With(can(auth.ResourceTasks, auth.VerbCreate), idempotent).Post("/", a.StartTaskHandler)
 
-		r.Get("/", a.GetTasksHandler)
+		r.With(can(auth.ResourceTasks, auth.VerbList)).Get("/", a.GetTasksHandler)
 
 		r.Route("/{taskID}", func(r chi.Router) {
-			r.Delete("/", a.StopTaskHandler)
+			r.With(can(auth.ResourceTasks, auth.VerbGet)).Get("/", a.GetTaskHandler)
+			r.With(can(auth.ResourceTasks, auth.VerbUpdate)).Patch("/", a.UpdateTaskHandler)
+			r.With(can(auth.ResourceTasks, auth.VerbDelete)).Delete("/", a.StopTaskHandler)
+			r.With(can(auth.ResourceTasks, auth.VerbDelete)).Post("/kill", a.KillTaskHandler)
+			r.With(can(auth.ResourceTasks, auth.VerbUpdate)).Post("/pause", a.PauseTaskHandler)
+			r.With(can(auth.ResourceTasks, auth.VerbUpdate)).Post("/resume", a.ResumeTaskHandler)
+			r.With(can(auth.ResourceTasks, auth.VerbUpdate)).Post("/restart", a.RestartTaskHandler)
+			r.With(can(auth.ResourceLogs, auth.VerbGet)).Get("/logs", a.GetTaskLogsHandler)
+
+			r.Group(func(r chi.Router) {
+				r.Use(can(auth.ResourceTasks, auth.VerbExec))
+
+				r.Post("/exec", a.ExecTaskHandler)
+				r.Get("/exec/ws", a.ExecWebSocketHandler)
+			})
 		})
 	})
 
+	a.Router.Route("/volumes", func(r chi.Router) {
+		r.With(can(auth.ResourceVolumes, auth.VerbList)).Get("/", a.GetVolumesHandler)
+		r.With(can(auth.ResourceVolumes, auth.VerbDelete)).Delete("/{volume}", a.RemoveVolumeHandler)
+	})
+
+	a.Router.Route("/networks", func(r chi.Router) {
+		r.With(can(auth.ResourceNetworks, auth.VerbList)).Get("/", a.GetNetworksHandler)
+	})
+
 	a.Router.Route("/stats", func(r chi.Router) {
-		r.Get("/", a.GetStatsHandler)
+		r.With(can(auth.ResourceStats, auth.VerbGet)).Get("/", a.GetStatsHandler)
 	})
 }
 
 func (a *Api) Start() {
 	a.SetupRoutes()
-	http.ListenAndServe(fmt.Sprintf("%s:%d", a.Address, a.Port), a.Router)
+	addr := fmt.Sprintf("%s:%d", a.Address, a.Port)
+
+	if a.TLSConfig == nil {
+		http.ListenAndServe(addr, a.Router)
+		return
+	}
+
+	srv := &http.Server{Addr: addr, Handler: a.Router, TLSConfig: a.TLSConfig}
+	// the certificates come from TLSConfig, so that they can be rotated
+	srv.ListenAndServeTLS("", "")
 }
@@ -0,0 +1,73 @@
+package worker
+
+import (
+	"Mine-Cube/task"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+)
+
+// SetConfigs holds on to the config contents delivered with a task until
+// the task is started.
+func (w *Worker) SetConfigs(id uuid.UUID, values map[string][]byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.configs == nil {
+		w.configs = make(map[uuid.UUID]map[string][]byte)
+	}
+	w.configs[id] = values
+}
+
+func (w *Worker) configsDir(id uuid.UUID) string {
+	return filepath.Join(w.ConfigsDir, id.String())
+}
+
+// injectConfigs writes the task's configs to the configs directory and bind
+// mounts them read-only into its container.
+func (w *Worker) injectConfigs(t *task.Task, config *task.Config) error {
+	if len(t.Configs) == 0 {
+		return nil
+	}
+
+	w.mu.Lock()
+	values := w.configs[t.ID]
+	delete(w.configs, t.ID)
+	w.mu.Unlock()
+
+	if w.ConfigsDir == "" {
+		return fmt.Errorf("task uses configs but the worker has no configs directory")
+	}
+
+	for _, ref := range t.Configs {
+		value, ok := values[ref.Name]
+		if !ok {
+			return fmt.Errorf("config %q was not delivered with the task", ref.Name)
+		}
+
+		m, err := mountFile(filepath.Join(w.configsDir(t.ID), ref.Name), value, ref.Mode, ref.File)
+		if err != nil {
+			return fmt.Errorf("error writing config %q: %w", ref.Name, err)
+		}
+		config.Mounts = append(config.Mounts, m)
+	}
+
+	return nil
+}
+
+// removeConfigs deletes the config files of a task that is gone.
+func (w *Worker) removeConfigs(id uuid.UUID) {
+	w.mu.Lock()
+	delete(w.configs, id)
+	w.mu.Unlock()
+
+	if w.ConfigsDir == "" {
+		return
+	}
+
+	if err := os.RemoveAll(w.configsDir(id)); err != nil {
+		log.WithField("task_id", id).Warnf("Error removing config files: %v", err)
+	}
+}
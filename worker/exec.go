@@ -0,0 +1,185 @@
+package worker
+
+import (
+	"Mine-Cube/task"
+	httputil "Mine-Cube/utils/http"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/gorilla/websocket"
+)
+
+var execUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	// Exec requests are authenticated by their bearer token or client
+	// certificate, never by cookies, so a cross-origin page cannot use a
+	// browser's credentials. The origin is not checked either: requests
+	// proxied by the manager carry the manager's host as their origin,
+	// not the worker's.
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// execControlMessage is sent as a text frame on the exec WebSocket. Clients
+// send "resize" when their terminal changes size, and the worker sends
+// "exit" once the command has finished. Binary frames carry the command's
+// stdin and output.
+type execControlMessage struct {
+	Type     string `json:"type"`
+	Rows     uint   `json:"rows,omitempty"`
+	Cols     uint   `json:"cols,omitempty"`
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error,omitempty"`
+}
+
+// wsWriter sends everything written to it as binary WebSocket frames.
+type wsWriter struct {
+	conn *websocket.Conn
+}
+
+func (ww *wsWriter) Write(p []byte) (int, error) {
+	if err := ww.conn.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (a *Api) ExecTaskHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := a.getRunningTask(w, r)
+	if !ok {
+		return
+	}
+
+	opts, err := httputil.DecodeJSON[task.ExecOptions](r)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("%v", err))
+		return
+	}
+
+	if len(opts.Cmd) == 0 {
+		httputil.WriteError(w, http.StatusBadRequest, "Cmd must not be empty")
+		return
+	}
+
+	if opts.Tty {
+		httputil.WriteError(w, http.StatusBadRequest, "Interactive sessions must use the exec WebSocket endpoint")
+		return
+	}
+
+	result, err := a.Worker.ExecTask(r.Context(), *t, opts)
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, fmt.Sprintf("Error executing command: %v", err))
+		return
+	}
+
+	handlerLog.WithFields(map[string]interface{}{
+		"task_id":   t.ID,
+		"command":   opts.Cmd[0],
+		"exit_code": result.ExitCode,
+	}).Info("Command executed in task via API")
+
+	httputil.WriteJSON(w, http.StatusOK, result)
+}
+
+// ExecWebSocketHandler runs an interactive command in the task's container.
+// The command and its options are given as query parameters, for example
+// ?cmd=sh&cmd=-c&cmd=ls&tty=true, since WebSocket handshakes carry no body.
+func (a *Api) ExecWebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := a.getRunningTask(w, r)
+	if !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	opts := task.ExecOptions{
+		Cmd:        q["cmd"],
+		Env:        q["env"],
+		User:       q.Get("user"),
+		WorkingDir: q.Get("workdir"),
+	}
+
+	if len(opts.Cmd) == 0 {
+		httputil.WriteError(w, http.StatusBadRequest, "cmd must not be empty")
+		return
+	}
+
+	var err error
+	if opts.Tty, err = httputil.GetBoolQuery(r, "tty", true); err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx := r.Context()
+
+	session, err := a.Worker.AttachExec(ctx, *t, opts)
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, fmt.Sprintf("Error executing command: %v", err))
+		return
+	}
+	defer session.Close()
+
+	// Upgrade writes its own error response on failure
+	conn, err := execUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	handlerLog.WithFields(map[string]interface{}{
+		"task_id": t.ID,
+		"command": opts.Cmd[0],
+		"tty":     opts.Tty,
+	}).Info("Interactive exec session started")
+
+	go func() {
+		for {
+			mt, data, err := conn.ReadMessage()
+			if err != nil {
+				session.CloseWrite()
+				return
+			}
+
+			switch mt {
+			case websocket.BinaryMessage:
+				if _, err := session.Conn.Write(data); err != nil {
+					return
+				}
+			case websocket.TextMessage:
+				var msg execControlMessage
+				if err := json.Unmarshal(data, &msg); err != nil || msg.Type != "resize" {
+					continue
+				}
+				if err := session.Resize(ctx, msg.Rows, msg.Cols); err != nil {
+					handlerLog.WithField("task_id", t.ID).Warnf("Error resizing exec terminal: %v", err)
+				}
+			}
+		}
+	}()
+
+	out := &wsWriter{conn: conn}
+	if opts.Tty {
+		_, err = io.Copy(out, session.Reader)
+	} else {
+		_, err = stdcopy.StdCopy(out, out, session.Reader)
+	}
+	if err != nil {
+		handlerLog.WithField("task_id", t.ID).Debugf("Exec output stream ended: %v", err)
+	}
+
+	exit := execControlMessage{Type: "exit"}
+	if exit.ExitCode, err = session.ExitCode(ctx); err != nil {
+		exit.ExitCode = -1
+		exit.Error = err.Error()
+	}
+
+	conn.WriteJSON(exit)
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+
+	handlerLog.WithFields(map[string]interface{}{
+		"task_id":   t.ID,
+		"exit_code": exit.ExitCode,
+	}).Info("Interactive exec session ended")
+}
@@ -0,0 +1,68 @@
+package worker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	nethttputil "net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestExecUpgradeThroughProxy upgrades a browser's request, whose origin is
+// the manager's, after the manager has passed it on to the worker.
+func TestExecUpgradeThroughProxy(t *testing.T) {
+	worker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := execUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		mt, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.WriteMessage(mt, data)
+	}))
+	defer worker.Close()
+
+	target, err := url.Parse(worker.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manager := httptest.NewServer(&nethttputil.ReverseProxy{
+		Rewrite: func(pr *nethttputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.SetXForwarded()
+		},
+	})
+	defer manager.Close()
+
+	header := http.Header{}
+	header.Set("Origin", manager.URL)
+
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(manager.URL, "http"), header)
+	if err != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		t.Fatalf("upgrade through proxy failed (%d): %v", status, err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteMessage(websocket.BinaryMessage, []byte("ls\n")); err != nil {
+		t.Fatal(err)
+	}
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "ls\n" {
+		t.Errorf("got %q, want %q", data, "ls\n")
+	}
+}
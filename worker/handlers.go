@@ -1,21 +1,43 @@
 package worker
 
 import (
+	"Mine-Cube/auth"
 	"Mine-Cube/logger"
 	"Mine-Cube/task"
 	httputil "Mine-Cube/utils/http"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/google/uuid"
 )
 
 var handlerLog = logger.GetLogger("worker.api")
 
 func (a *Api) StartTaskHandler(w http.ResponseWriter, r *http.Request) {
-	te, err := httputil.DecodeJSON[task.TaskEvent](r)
+	req, err := httputil.DecodeJSON[task.WorkRequest](r)
 	if err != nil {
 		httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("%v", err))
 		return
 	}
+	te := req.TaskEvent
+
+	if !auth.AllowedNamespace(r.Context(), te.Task.Namespace) {
+		httputil.WriteError(w, http.StatusForbidden, fmt.Sprintf("Not allowed in namespace: %s", te.Task.Namespace))
+		return
+	}
+
+	if len(req.Secrets) > 0 {
+		a.Worker.SetSecrets(te.Task.ID, req.Secrets)
+	}
+	if len(req.Configs) > 0 {
+		a.Worker.SetConfigs(te.Task.ID, req.Configs)
+	}
 
 	a.Worker.AddTask(te.Task)
 	handlerLog.WithField("task_id", te.Task.ID).Info("Task added via API")
@@ -23,19 +45,28 @@ func (a *Api) StartTaskHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *Api) GetTasksHandler(w http.ResponseWriter, r *http.Request) {
-	httputil.WriteJSON(w, http.StatusOK, a.Worker.GetTasks())
+	tasks := []task.Task{}
+	for _, t := range a.Worker.GetTasks() {
+		if auth.AllowedNamespace(r.Context(), t.Namespace) {
+			tasks = append(tasks, t)
+		}
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, tasks)
 }
 
-func (a *Api) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
-	tID, err := httputil.GetUUIDParam(r, "taskID")
-	if err != nil {
-		httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("No taskID passed in request: %v", err))
+func (a *Api) GetTaskHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := a.getTask(w, r)
+	if !ok {
 		return
 	}
 
-	taskToStop, ok := a.Worker.Db[tID]
+	httputil.WriteJSON(w, http.StatusOK, t)
+}
+
+func (a *Api) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
+	taskToStop, ok := a.getTask(w, r)
 	if !ok {
-		httputil.WriteError(w, http.StatusNotFound, fmt.Sprintf("No task found with ID: %v", tID))
 		return
 	}
 
@@ -51,6 +82,364 @@ func (a *Api) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
 	httputil.WriteNoContent(w)
 }
 
+// KillTaskHandler kills the task's container right away instead of queueing
+// a stop, so that it can also cut short a stop that is waiting out the
+// task's grace period.
+func (a *Api) KillTaskHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := a.getTask(w, r)
+	if !ok {
+		return
+	}
+
+	if t.State != task.Running && t.State != task.Stopping && t.State != task.Paused {
+		httputil.WriteError(w, http.StatusConflict, fmt.Sprintf("Task %v is not running", t.ID))
+		return
+	}
+
+	result := a.Worker.KillTask(*t)
+	if result.Error != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, fmt.Sprintf("Error killing task: %v", result.Error))
+		return
+	}
+
+	handlerLog.WithFields(map[string]interface{}{
+		"task_id":      t.ID,
+		"container_id": t.ContainerID,
+	}).Info("Task killed via API")
+
+	a.writeTask(w, t.ID)
+}
+
+// UpdateTaskHandler applies the resource limits and restart policy of the
+// task in the request body to the task's running container.
+func (a *Api) UpdateTaskHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := a.getTask(w, r)
+	if !ok {
+		return
+	}
+
+	spec, err := httputil.DecodeJSON[task.Task](r)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("%v", err))
+		return
+	}
+	if spec.ID != t.ID {
+		httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("Task ID %v does not match %v", spec.ID, t.ID))
+		return
+	}
+
+	if t.State != task.Running && t.State != task.Paused {
+		httputil.WriteError(w, http.StatusConflict, fmt.Sprintf("Task %v is not running", t.ID))
+		return
+	}
+
+	result := a.Worker.UpdateTask(*t, spec)
+	if result.Error != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, fmt.Sprintf("Error updating task: %v", result.Error))
+		return
+	}
+
+	handlerLog.WithFields(map[string]interface{}{
+		"task_id":      t.ID,
+		"container_id": t.ContainerID,
+	}).Info("Task updated via API")
+
+	a.writeTask(w, t.ID)
+}
+
+func (a *Api) PauseTaskHandler(w http.ResponseWriter, r *http.Request) {
+	a.changeTaskState(w, r, task.Paused, a.Worker.PauseTask)
+}
+
+func (a *Api) ResumeTaskHandler(w http.ResponseWriter, r *http.Request) {
+	a.changeTaskState(w, r, task.Running, a.Worker.ResumeTask)
+}
+
+// changeTaskState moves the task named in the URL to state by running
+// action on it right away, and returns the updated task.
+func (a *Api) changeTaskState(w http.ResponseWriter, r *http.Request, state task.State, action func(task.Task) task.DockerResult) {
+	t, ok := a.getTask(w, r)
+	if !ok {
+		return
+	}
+
+	if t.State == state || !task.ValidStateTransition(t.State, state) {
+		httputil.WriteError(w, http.StatusConflict, fmt.Sprintf("Task %v cannot go from %s to %s", t.ID, t.State, state))
+		return
+	}
+
+	result := action(*t)
+	if result.Error != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, fmt.Sprintf("Error changing task state: %v", result.Error))
+		return
+	}
+
+	handlerLog.WithFields(map[string]interface{}{
+		"task_id":      t.ID,
+		"container_id": t.ContainerID,
+		"action":       result.Action,
+	}).Info("Task state changed via API")
+
+	a.writeTask(w, t.ID)
+}
+
+// RestartTaskHandler queues a restart of the task in place: it keeps its ID
+// and gets a new container on this worker.
+func (a *Api) RestartTaskHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := a.getTask(w, r)
+	if !ok {
+		return
+	}
+
+	if !task.ValidStateTransition(t.State, task.Restarting) {
+		httputil.WriteError(w, http.StatusConflict, fmt.Sprintf("Task %v cannot be restarted while %s", t.ID, t.State))
+		return
+	}
+
+	taskCopy := *t
+	taskCopy.State = task.Restarting
+	taskCopy.RestartCount++
+	a.Worker.AddTask(taskCopy)
+
+	handlerLog.WithFields(map[string]interface{}{
+		"task_id":       t.ID,
+		"restart_count": taskCopy.RestartCount,
+	}).Info("Task restart requested via API")
+
+	httputil.WriteJSON(w, http.StatusAccepted, taskCopy)
+}
+
+// getTask looks up the task named in the URL, writing an error response
+// when it does not exist or lies outside the caller's namespaces.
+func (a *Api) getTask(w http.ResponseWriter, r *http.Request) (*task.Task, bool) {
+	tID, err := httputil.GetUUIDParam(r, "taskID")
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("No taskID passed in request: %v", err))
+		return nil, false
+	}
+
+	t, ok := a.Worker.GetTask(tID)
+	if !ok {
+		httputil.WriteError(w, http.StatusNotFound, fmt.Sprintf("No task found with ID: %v", tID))
+		return nil, false
+	}
+
+	if !auth.AllowedNamespace(r.Context(), t.Namespace) {
+		httputil.WriteError(w, http.StatusForbidden, fmt.Sprintf("Not allowed in namespace: %s", t.Namespace))
+		return nil, false
+	}
+
+	return &t, true
+}
+
+// writeTask responds with the current state of a task.
+func (a *Api) writeTask(w http.ResponseWriter, id uuid.UUID) {
+	t, _ := a.Worker.GetTask(id)
+	httputil.WriteJSON(w, http.StatusOK, t)
+}
+
+// getRunningTask is getTask for operations that need a running container.
+func (a *Api) getRunningTask(w http.ResponseWriter, r *http.Request) (*task.Task, bool) {
+	t, ok := a.getTask(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	if t.State != task.Running {
+		httputil.WriteError(w, http.StatusConflict, fmt.Sprintf("Task %v is not running", t.ID))
+		return nil, false
+	}
+
+	return t, true
+}
+
 func (a *Api) GetStatsHandler(w http.ResponseWriter, r *http.Request) {
 	httputil.WriteJSON(w, http.StatusOK, a.Worker.Stats)
 }
+
+func parseLogOptions(r *http.Request) (task.LogOptions, error) {
+	q := r.URL.Query()
+
+	opts := task.LogOptions{
+		Tail:  "all",
+		Since: q.Get("since"),
+		Until: q.Get("until"),
+	}
+
+	if tail := q.Get("tail"); tail != "" && tail != "all" {
+		if n, err := strconv.Atoi(tail); err != nil || n < 0 {
+			return opts, fmt.Errorf("invalid tail value: %q", tail)
+		}
+		opts.Tail = tail
+	}
+
+	var err error
+	if opts.Follow, err = httputil.GetBoolQuery(r, "follow", false); err != nil {
+		return opts, err
+	}
+	if opts.Timestamps, err = httputil.GetBoolQuery(r, "timestamps", false); err != nil {
+		return opts, err
+	}
+	if opts.Stdout, err = httputil.GetBoolQuery(r, "stdout", true); err != nil {
+		return opts, err
+	}
+	if opts.Stderr, err = httputil.GetBoolQuery(r, "stderr", true); err != nil {
+		return opts, err
+	}
+
+	if !opts.Stdout && !opts.Stderr {
+		return opts, fmt.Errorf("at least one of stdout or stderr must be selected")
+	}
+
+	return opts, nil
+}
+
+func (a *Api) GetTaskLogsHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := a.getTask(w, r)
+	if !ok {
+		return
+	}
+	tID := t.ID
+
+	opts, err := parseLogOptions(r)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if t.State == task.Completed || t.State == task.Failed {
+		a.writeStoredLogs(w, r, tID, opts)
+		return
+	}
+
+	if t.ContainerID == "" {
+		httputil.WriteError(w, http.StatusConflict, fmt.Sprintf("Task %v has no container", tID))
+		return
+	}
+
+	rc, err := a.Worker.TaskLogs(r.Context(), *t, opts)
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, fmt.Sprintf("Error reading task logs: %v", err))
+		return
+	}
+	defer rc.Close()
+
+	if httputil.WantsSSE(r) {
+		httputil.WriteSSEHeader(w)
+
+		stdout := &httputil.SSELineWriter{W: w, Event: "stdout"}
+		stderr := &httputil.SSELineWriter{W: w, Event: "stderr"}
+
+		_, err = stdcopy.StdCopy(stdout, stderr, rc)
+		stdout.Close()
+		stderr.Close()
+	} else {
+		httputil.WriteStreamHeader(w, "text/plain; charset=utf-8")
+
+		fw := httputil.NewFlushWriter(w)
+		_, err = stdcopy.StdCopy(fw, fw, rc)
+	}
+
+	if err != nil && r.Context().Err() == nil {
+		handlerLog.WithField("task_id", tID).Warnf("Log stream ended with error: %v", err)
+	}
+}
+
+// writeStoredLogs serves the logs captured on disk, for tasks whose container
+// may already be gone.
+func (a *Api) writeStoredLogs(w http.ResponseWriter, r *http.Request, tID uuid.UUID, opts task.LogOptions) {
+	if a.Worker.Logs == nil || !a.Worker.Logs.Has(tID) {
+		httputil.WriteError(w, http.StatusNotFound, fmt.Sprintf("No logs stored for task: %v", tID))
+		return
+	}
+
+	sse := httputil.WantsSSE(r)
+	if sse {
+		httputil.WriteSSEHeader(w)
+	} else {
+		httputil.WriteStreamHeader(w, "text/plain; charset=utf-8")
+	}
+
+	fw := httputil.NewFlushWriter(w)
+
+	err := a.Worker.Logs.Read(tID, opts, func(rec LogRecord) error {
+		line := rec.Line
+		if opts.Timestamps {
+			line = rec.Time.Format(time.RFC3339Nano) + " " + line
+		}
+
+		if sse {
+			return httputil.WriteSSE(w, "", rec.Stream, line)
+		}
+
+		_, err := fmt.Fprintln(fw, line)
+		return err
+	})
+
+	if err != nil && r.Context().Err() == nil {
+		handlerLog.WithField("task_id", tID).Warnf("Error reading stored logs: %v", err)
+	}
+}
+
+func (a *Api) GetVolumesHandler(w http.ResponseWriter, r *http.Request) {
+	volumes, err := a.Worker.GetVolumes(r.Context())
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, fmt.Sprintf("Error listing volumes: %v", err))
+		return
+	}
+
+	allowed := []*volume.Volume{}
+	for _, v := range volumes {
+		if auth.AllowedNamespace(r.Context(), v.Labels[task.NamespaceLabel]) {
+			allowed = append(allowed, v)
+		}
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, allowed)
+}
+
+func (a *Api) GetNetworksHandler(w http.ResponseWriter, r *http.Request) {
+	networks, err := a.Worker.GetNetworks(r.Context())
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, fmt.Sprintf("Error listing networks: %v", err))
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, networks)
+}
+
+func (a *Api) RemoveVolumeHandler(w http.ResponseWriter, r *http.Request) {
+	name, err := httputil.GetURLParam(r, "volume")
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	v, err := a.Worker.GetVolume(r.Context(), name)
+	if cerrdefs.IsNotFound(err) || errors.Is(err, ErrVolumeNotManaged) {
+		httputil.WriteError(w, http.StatusNotFound, fmt.Sprintf("No volume found with name: %s", name))
+		return
+	}
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, fmt.Sprintf("Error inspecting volume %s: %v", name, err))
+		return
+	}
+
+	if namespace := v.Labels[task.NamespaceLabel]; !auth.AllowedNamespace(r.Context(), namespace) {
+		httputil.WriteError(w, http.StatusForbidden, fmt.Sprintf("Not allowed in namespace: %s", namespace))
+		return
+	}
+
+	err = a.Worker.RemoveVolume(r.Context(), name)
+	if cerrdefs.IsNotFound(err) {
+		httputil.WriteError(w, http.StatusNotFound, fmt.Sprintf("No volume found with name: %s", name))
+		return
+	}
+	if err != nil {
+		httputil.WriteError(w, http.StatusConflict, fmt.Sprintf("Error removing volume %s: %v", name, err))
+		return
+	}
+
+	handlerLog.WithField("volume", name).Info("Volume removed via API")
+	httputil.WriteNoContent(w)
+}
@@ -0,0 +1,122 @@
+package worker
+
+import (
+	"Mine-Cube/task"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+var HEALTH_CHECK_INTERVAL = 30 * time.Second
+var HEALTH_CHECK_TIMEOUT = 5 * time.Second
+
+// containerURL builds the URL of path on the task's first tcp port from the
+// container's address on its own network, so that health probes and hooks
+// never have to go through a published host port.
+func containerURL(c *container.InspectResponse, t task.Task, path string) (string, error) {
+	if c.NetworkSettings == nil {
+		return "", errors.New("container has no network settings")
+	}
+
+	var ip string
+	for _, n := range c.NetworkSettings.Networks {
+		if n != nil && n.IPAddress != "" {
+			ip = n.IPAddress
+			break
+		}
+	}
+	if ip == "" {
+		return "", errors.New("container has no IP address")
+	}
+
+	for p := range t.ExposedPorts {
+		if p.Proto() == "tcp" {
+			return fmt.Sprintf("http://%s:%s%s", ip, p.Port(), path), nil
+		}
+	}
+
+	return "", errors.New("task exposes no tcp port")
+}
+
+func (w *Worker) checkTaskHealth(t task.Task) (string, error) {
+	resp := w.InspectTask(t)
+	if resp.Error != nil {
+		return "", fmt.Errorf("error inspecting container: %w", resp.Error)
+	}
+
+	url, err := containerURL(resp.Container, t, t.HealthCheck)
+	if err != nil {
+		return "", err
+	}
+
+	log.WithFields(map[string]interface{}{
+		"task_id": t.ID,
+		"url":     url,
+	}).Debug("Calling health check endpoint")
+
+	client := http.Client{Timeout: HEALTH_CHECK_TIMEOUT}
+
+	res, err := client.Get(url)
+	if err != nil {
+		return "", fmt.Errorf("health check connection error: %w", err)
+	}
+	defer res.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+
+	if res.StatusCode != http.StatusOK {
+		return string(body), fmt.Errorf("health check returned status %d", res.StatusCode)
+	}
+
+	return string(body), nil
+}
+
+func (w *Worker) doHealthChecks() {
+	for _, t := range w.GetTasks() {
+		if t.State != task.Running || t.HealthCheck == "" {
+			continue
+		}
+
+		id := t.ID
+		output, err := w.checkTaskHealth(t)
+
+		health := task.Health{
+			Status:    task.Healthy,
+			Message:   output,
+			CheckedAt: time.Now().UTC(),
+		}
+
+		if err != nil {
+			log.WithField("task_id", id).Warnf("Health check failed: %v", err)
+			health.Status = task.Unhealthy
+			health.Message = err.Error()
+			health.Failures = t.Health.Failures + 1
+		} else {
+			log.WithField("task_id", id).Debug("Health check passed")
+		}
+
+		w.mu.Lock()
+		// a new container starts with a fresh health
+		if current, ok := w.Db[id]; ok && current.ContainerID == t.ContainerID {
+			current.Health = health
+		}
+		w.mu.Unlock()
+	}
+}
+
+func (w *Worker) DoHealthChecks() {
+	for {
+		log.WithFields(map[string]interface{}{
+			"interval":   HEALTH_CHECK_INTERVAL,
+			"task_count": w.taskCount(),
+		}).Debug("Performing task health checks")
+
+		w.doHealthChecks()
+
+		time.Sleep(HEALTH_CHECK_INTERVAL)
+	}
+}
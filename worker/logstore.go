@@ -0,0 +1,401 @@
+package worker
+
+import (
+	"Mine-Cube/task"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/google/uuid"
+)
+
+var LOG_MAX_SIZE int64 = 10 * 1024 * 1024
+var LOG_MAX_FILES = 5
+var LOG_RETENTION = 7 * 24 * time.Hour
+var PRUNE_LOGS_INTERVAL = 1 * time.Hour
+
+const logFileName = "output.log"
+
+// LogRecord is one line of a task's output as stored on disk.
+type LogRecord struct {
+	Time   time.Time
+	Stream string
+	Line   string
+}
+
+// LogStore keeps a copy of each task's stdout and stderr on disk, so the
+// output is still available after the container has been removed. Every
+// task gets its own directory holding output.log and up to LOG_MAX_FILES-1
+// rotated files (output.log.1 being the most recent).
+type LogStore struct {
+	Dir string
+}
+
+func NewLogStore(dir string) *LogStore {
+	return &LogStore{Dir: dir}
+}
+
+func (s *LogStore) taskDir(id uuid.UUID) string {
+	return filepath.Join(s.Dir, id.String())
+}
+
+// Has reports whether any output has been stored for the task.
+func (s *LogStore) Has(id uuid.UUID) bool {
+	_, err := os.Stat(filepath.Join(s.taskDir(id), logFileName))
+	return err == nil
+}
+
+func (s *LogStore) open(id uuid.UUID) (*rotatingFile, error) {
+	dir := s.taskDir(id)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	rf := &rotatingFile{
+		path:     filepath.Join(dir, logFileName),
+		maxSize:  LOG_MAX_SIZE,
+		maxFiles: LOG_MAX_FILES,
+	}
+	if err := rf.openFile(); err != nil {
+		return nil, err
+	}
+
+	return rf, nil
+}
+
+// Read calls fn for each stored record that matches opts, oldest first.
+// Follow is ignored since a stored log no longer grows.
+func (s *LogStore) Read(id uuid.UUID, opts task.LogOptions, fn func(LogRecord) error) error {
+	now := time.Now()
+
+	since, err := parseLogTime(opts.Since, now)
+	if err != nil {
+		return fmt.Errorf("invalid since value: %w", err)
+	}
+	until, err := parseLogTime(opts.Until, now)
+	if err != nil {
+		return fmt.Errorf("invalid until value: %w", err)
+	}
+
+	tail := -1
+	if opts.Tail != "" && opts.Tail != "all" {
+		tail, err = strconv.Atoi(opts.Tail)
+		if err != nil {
+			return fmt.Errorf("invalid tail value: %q", opts.Tail)
+		}
+	}
+
+	var files []string
+	base := filepath.Join(s.taskDir(id), logFileName)
+	for i := LOG_MAX_FILES - 1; i >= 1; i-- {
+		files = append(files, fmt.Sprintf("%s.%d", base, i))
+	}
+	files = append(files, base)
+
+	// With a tail only the last records are kept, in a ring buffer
+	var ring []LogRecord
+	next := 0
+
+	for _, path := range files {
+		f, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+		for scanner.Scan() {
+			var rec LogRecord
+			if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+				continue
+			}
+
+			if (rec.Stream == "stdout" && !opts.Stdout) || (rec.Stream == "stderr" && !opts.Stderr) {
+				continue
+			}
+			if !since.IsZero() && rec.Time.Before(since) {
+				continue
+			}
+			if !until.IsZero() && rec.Time.After(until) {
+				continue
+			}
+
+			if tail < 0 {
+				if err := fn(rec); err != nil {
+					f.Close()
+					return err
+				}
+				continue
+			}
+
+			if tail == 0 {
+				continue
+			}
+			if len(ring) < tail {
+				ring = append(ring, rec)
+			} else {
+				ring[next] = rec
+				next = (next + 1) % tail
+			}
+		}
+
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+
+	for i := range ring {
+		if err := fn(ring[(next+i)%len(ring)]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Prune removes the logs of finished tasks once nothing has been written to
+// them for the retention period. active reports whether a task is still
+// running and must keep its logs regardless of age.
+func (s *LogStore) Prune(retention time.Duration, active func(uuid.UUID) bool) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Errorf("Error reading log directory: %v", err)
+		}
+		return
+	}
+
+	for _, e := range entries {
+		id, err := uuid.Parse(e.Name())
+		if err != nil || !e.IsDir() || active(id) {
+			continue
+		}
+
+		info, err := os.Stat(filepath.Join(s.taskDir(id), logFileName))
+		if err == nil && time.Since(info.ModTime()) < retention {
+			continue
+		}
+
+		log.WithField("task_id", id).Info("Removing task logs past retention")
+
+		if err := os.RemoveAll(s.taskDir(id)); err != nil {
+			log.WithField("task_id", id).Errorf("Error removing task logs: %v", err)
+		}
+	}
+}
+
+// parseLogTime accepts the same formats as the Docker logs API: an RFC3339
+// timestamp, Unix seconds, or a duration relative to now.
+func parseLogTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+
+	if secs, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Unix(0, int64(secs*float64(time.Second))), nil
+	}
+
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+
+	return time.Time{}, fmt.Errorf("unrecognised time %q", value)
+}
+
+// rotatingFile is an append-only file that is rotated once it would grow
+// past maxSize.
+type rotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	f        *os.File
+	size     int64
+}
+
+func (rf *rotatingFile) openFile() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	rf.f = f
+	rf.size = info.Size()
+	return nil
+}
+
+func (rf *rotatingFile) rotate() error {
+	if err := rf.f.Close(); err != nil {
+		return err
+	}
+
+	os.Remove(fmt.Sprintf("%s.%d", rf.path, rf.maxFiles-1))
+	for i := rf.maxFiles - 2; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+	}
+
+	if rf.maxFiles > 1 {
+		if err := os.Rename(rf.path, rf.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(rf.path); err != nil {
+		return err
+	}
+
+	return rf.openFile()
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	return rf.f.Close()
+}
+
+// recordWriter splits one stream of timestamped Docker output into lines
+// and stores each of them as a LogRecord.
+type recordWriter struct {
+	out    *rotatingFile
+	stream string
+	buf    []byte
+}
+
+func (rw *recordWriter) Write(p []byte) (int, error) {
+	rw.buf = append(rw.buf, p...)
+
+	for {
+		i := bytes.IndexByte(rw.buf, '\n')
+		if i < 0 {
+			break
+		}
+
+		line := string(rw.buf[:i])
+		rw.buf = rw.buf[i+1:]
+
+		if err := rw.writeLine(line); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+func (rw *recordWriter) writeLine(line string) error {
+	rec := LogRecord{Time: time.Now().UTC(), Stream: rw.stream}
+
+	ts, rest, ok := strings.Cut(line, " ")
+	if t, err := time.Parse(time.RFC3339Nano, ts); ok && err == nil {
+		rec.Time = t
+		line = rest
+	}
+	rec.Line = strings.TrimSuffix(line, "\r")
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	_, err = rw.out.Write(append(data, '\n'))
+	return err
+}
+
+func (rw *recordWriter) Close() error {
+	if len(rw.buf) == 0 {
+		return nil
+	}
+
+	line := string(rw.buf)
+	rw.buf = nil
+	return rw.writeLine(line)
+}
+
+// captureLogs follows the task's container output and stores it in the log
+// store until the container stops.
+func (w *Worker) captureLogs(t task.Task) {
+	rc, err := w.TaskLogs(context.Background(), t, task.LogOptions{
+		Follow:     true,
+		Timestamps: true,
+		Stdout:     true,
+		Stderr:     true,
+	})
+	if err != nil {
+		log.WithField("task_id", t.ID).Errorf("Error capturing task logs: %v", err)
+		return
+	}
+	defer rc.Close()
+
+	out, err := w.Logs.open(t.ID)
+	if err != nil {
+		log.WithField("task_id", t.ID).Errorf("Error opening task log file: %v", err)
+		return
+	}
+	defer out.Close()
+
+	stdout := &recordWriter{out: out, stream: "stdout"}
+	stderr := &recordWriter{out: out, stream: "stderr"}
+
+	log.WithField("task_id", t.ID).Debug("Capturing task logs")
+
+	if _, err := stdcopy.StdCopy(stdout, stderr, rc); err != nil {
+		log.WithField("task_id", t.ID).Warnf("Task log capture ended with error: %v", err)
+	}
+
+	stdout.Close()
+	stderr.Close()
+}
+
+func (w *Worker) PruneLogs() {
+	for {
+		log.WithFields(map[string]interface{}{
+			"interval":  PRUNE_LOGS_INTERVAL,
+			"retention": LOG_RETENTION,
+		}).Debug("Pruning task logs")
+
+		w.Logs.Prune(LOG_RETENTION, func(id uuid.UUID) bool {
+			t, ok := w.GetTask(id)
+			return ok && t.State != task.Completed && t.State != task.Failed
+		})
+
+		time.Sleep(PRUNE_LOGS_INTERVAL)
+	}
+}
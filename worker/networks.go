@@ -0,0 +1,66 @@
+package worker
+
+import (
+	"Mine-Cube/task"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types/network"
+)
+
+var PRUNE_NETWORKS_INTERVAL = 60 * time.Second
+
+// prepareNetworks creates the networks the task joins that do not exist on
+// this worker yet.
+func (w *Worker) prepareNetworks(d *task.Docker, t *task.Task) error {
+	for _, name := range t.Networks {
+		labels := map[string]string{
+			task.NamespaceLabel:   t.Namespace,
+			task.NetworkNameLabel: name,
+		}
+		if err := d.EnsureNetwork(context.Background(), task.DockerNetworkName(t.Namespace, name), labels); err != nil {
+			return fmt.Errorf("error creating network %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// pruneNetworks removes the networks created for tasks that no container is
+// attached to anymore. It runs on the same goroutine that starts tasks, so a
+// network is never removed between being created and being joined.
+func (w *Worker) pruneNetworks() {
+	d := task.NewDocker(task.Config{})
+	if d == nil {
+		log.Error("Failed to create Docker client for pruning networks")
+		return
+	}
+
+	networks, err := d.ListNetworks(context.Background(), task.NetworkNameLabel)
+	if err != nil {
+		log.Errorf("Error listing networks: %v", err)
+		return
+	}
+
+	for _, n := range networks {
+		if len(n.Containers) > 0 {
+			continue
+		}
+		// another one may still be attached, in which case Docker refuses
+		if err := d.RemoveNetwork(context.Background(), n.Name); err != nil {
+			log.WithField("network", n.Name).Warnf("Error pruning network: %v", err)
+		}
+	}
+}
+
+// GetNetworks lists the networks created for tasks on this worker.
+func (w *Worker) GetNetworks(ctx context.Context) ([]network.Inspect, error) {
+	d := task.NewDocker(task.Config{})
+	if d == nil {
+		return nil, errors.New("failed to create Docker client")
+	}
+
+	return d.ListNetworks(ctx, task.NetworkNameLabel)
+}
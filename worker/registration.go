@@ -0,0 +1,109 @@
+package worker
+
+import (
+	"Mine-Cube/pki"
+	httputil "Mine-Cube/utils/http"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Registrar obtains the worker's certificate from the manager's CA and
+// renews it before it expires. The first registration authenticates with
+// the join token; renewals may also rely on the current certificate.
+type Registrar struct {
+	// ManagerAddress is the host:port of the manager's API
+	ManagerAddress string
+	JoinToken      string
+	Name           string
+	// Address is the host:port the manager reaches this worker's API at
+	Address string
+	KeyPair *pki.KeyPair
+
+	client *http.Client
+}
+
+func NewRegistrar(managerAddress string, roots *x509.CertPool, name string, address string) *Registrar {
+	kp := &pki.KeyPair{}
+
+	return &Registrar{
+		ManagerAddress: managerAddress,
+		Name:           name,
+		Address:        address,
+		KeyPair:        kp,
+		client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: pki.ClientConfig(kp, roots, pki.OrgManager)},
+		},
+	}
+}
+
+// ServerConfig returns the TLS config for the worker's API. Only clients
+// with a manager certificate from the cluster's CA may connect.
+func (r *Registrar) ServerConfig(roots *x509.CertPool) *tls.Config {
+	return pki.ServerConfig(r.KeyPair, roots, tls.RequireAndVerifyClientCert, pki.OrgManager)
+}
+
+// Register sends a fresh key's certificate request to the manager and
+// switches to the certificate it gets back.
+func (r *Registrar) Register() error {
+	// the manager decides which hosts the certificate is valid for
+	csrPEM, keyPEM, err := pki.NewCertificateRequest(r.Name, nil)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(pki.RegistrationRequest{
+		Name:    r.Name,
+		Address: r.Address,
+		CSR:     string(csrPEM),
+	})
+	if err != nil {
+		return fmt.Errorf("error marshalling registration request: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("https://%s/workers/register", r.ManagerAddress), bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.JoinToken != "" {
+		req.Header.Set("Authorization", "Bearer "+r.JoinToken)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error connecting to manager: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		e := httputil.ErrorResponse{}
+		json.NewDecoder(resp.Body).Decode(&e)
+		return fmt.Errorf("registration rejected (%d): %s", resp.StatusCode, e.Message)
+	}
+
+	result := pki.RegistrationResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("error decoding registration response: %w", err)
+	}
+
+	if err := r.KeyPair.Set([]byte(result.Certificate), keyPEM); err != nil {
+		return err
+	}
+
+	log.WithFields(map[string]interface{}{
+		"manager":   r.ManagerAddress,
+		"not_after": r.KeyPair.Leaf().NotAfter,
+	}).Info("Worker certificate issued by manager")
+
+	return nil
+}
+
+// Run registers the worker and keeps its certificate renewed. Until the
+// first registration succeeds the worker's API refuses TLS handshakes.
+func (r *Registrar) Run() {
+	r.KeyPair.KeepRenewed(r.Register)
+}
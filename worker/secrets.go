@@ -0,0 +1,126 @@
+package worker
+
+import (
+	"Mine-Cube/task"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/google/uuid"
+)
+
+var DEFAULT_FILE_MODE os.FileMode = 0444
+
+// DefaultSecretsDir is a directory on tmpfs where available, so that secret
+// files never reach the disk.
+func DefaultSecretsDir() string {
+	if info, err := os.Stat("/dev/shm"); err == nil && info.IsDir() {
+		return "/dev/shm/cube/secrets"
+	}
+	return filepath.Join(os.TempDir(), "cube", "secrets")
+}
+
+// SetSecrets holds on to the secret values delivered with a task until the
+// task is started.
+func (w *Worker) SetSecrets(id uuid.UUID, values map[string][]byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.secrets == nil {
+		w.secrets = make(map[uuid.UUID]map[string][]byte)
+	}
+	w.secrets[id] = values
+}
+
+func (w *Worker) secretsDir(id uuid.UUID) string {
+	return filepath.Join(w.SecretsDir, id.String())
+}
+
+// injectSecrets adds the task's secrets to its container config, as
+// environment variables or as read-only files bind mounted from the secrets
+// directory. The delivered values are dropped once they are in place.
+func (w *Worker) injectSecrets(t *task.Task, config *task.Config) error {
+	if len(t.Secrets) == 0 {
+		return nil
+	}
+
+	w.mu.Lock()
+	values := w.secrets[t.ID]
+	delete(w.secrets, t.ID)
+	w.mu.Unlock()
+
+	if w.SecretsDir == "" {
+		return fmt.Errorf("task uses secrets but the worker has no secrets directory")
+	}
+
+	dir := w.secretsDir(t.ID)
+
+	for i, ref := range t.Secrets {
+		value, ok := values[ref.Name]
+		if !ok {
+			return fmt.Errorf("secret %q was not delivered with the task", ref.Name)
+		}
+
+		if ref.Env != "" {
+			config.Env = append(config.Env, fmt.Sprintf("%s=%s", ref.Env, value))
+		}
+
+		if ref.File != "" {
+			// the index keeps two files of the same secret with different
+			// modes apart
+			path := filepath.Join(dir, fmt.Sprintf("%d-%s", i, ref.Name))
+			m, err := mountFile(path, value, ref.Mode, ref.File)
+			if err != nil {
+				return fmt.Errorf("error writing secret %q: %w", ref.Name, err)
+			}
+			config.Mounts = append(config.Mounts, m)
+		}
+	}
+
+	return nil
+}
+
+// removeSecrets deletes the secret files of a task that is gone.
+func (w *Worker) removeSecrets(id uuid.UUID) {
+	w.mu.Lock()
+	delete(w.secrets, id)
+	w.mu.Unlock()
+
+	if w.SecretsDir == "" {
+		return
+	}
+
+	if err := os.RemoveAll(w.secretsDir(id)); err != nil {
+		log.WithField("task_id", id).Warnf("Error removing secret files: %v", err)
+	}
+}
+
+// mountFile writes data to path on the host and returns a read-only bind
+// mount of it at target inside the container.
+func mountFile(path string, data []byte, mode os.FileMode, target string) (mount.Mount, error) {
+	if mode == 0 {
+		mode = DEFAULT_FILE_MODE
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return mount.Mount{}, err
+	}
+
+	// an earlier copy may not be writable
+	os.Remove(path)
+	if err := os.WriteFile(path, data, mode); err != nil {
+		return mount.Mount{}, err
+	}
+	// WriteFile's mode is subject to the umask
+	if err := os.Chmod(path, mode); err != nil {
+		return mount.Mount{}, err
+	}
+
+	return mount.Mount{
+		Type:     mount.TypeBind,
+		Source:   path,
+		Target:   target,
+		ReadOnly: true,
+	}, nil
+}
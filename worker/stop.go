@@ -0,0 +1,103 @@
+package worker
+
+import (
+	"Mine-Cube/task"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// runPreStop runs the task's pre-stop hook, if it has one. The task is
+// stopped whatever the outcome, so failures are only logged.
+func (w *Worker) runPreStop(t task.Task) {
+	h := t.PreStop
+	if h == nil || t.ContainerID == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.TimeoutDuration())
+	defer cancel()
+
+	log.WithField("task_id", t.ID).Info("Running pre-stop hook")
+
+	start := time.Now()
+
+	var err error
+	if h.HTTPGet != "" {
+		err = w.preStopHTTP(ctx, t)
+	} else {
+		err = w.preStopExec(ctx, t)
+	}
+
+	if err != nil {
+		log.WithFields(map[string]interface{}{
+			"task_id":  t.ID,
+			"duration": time.Since(start),
+		}).Warnf("Pre-stop hook failed: %v", err)
+		return
+	}
+
+	log.WithFields(map[string]interface{}{
+		"task_id":  t.ID,
+		"duration": time.Since(start),
+	}).Info("Pre-stop hook completed")
+}
+
+func (w *Worker) preStopHTTP(ctx context.Context, t task.Task) error {
+	resp := w.InspectTask(t)
+	if resp.Error != nil {
+		return fmt.Errorf("error inspecting container: %w", resp.Error)
+	}
+
+	url, err := containerURL(resp.Container, t, t.PreStop.HTTPGet)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 512))
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("pre-stop hook returned status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+func (w *Worker) preStopExec(ctx context.Context, t task.Task) error {
+	result, err := w.ExecTask(ctx, t, task.ExecOptions{Cmd: t.PreStop.Exec})
+	if err != nil {
+		return err
+	}
+
+	if result.ExitCode != 0 {
+		return fmt.Errorf("pre-stop command exited with code %d: %s", result.ExitCode, strings.TrimSpace(result.Stderr))
+	}
+
+	return nil
+}
+
+// stopReason describes how a task stopped on request ended.
+func stopReason(t task.Task, force bool, mode task.StopMode) string {
+	switch {
+	case force:
+		return "Killed on request"
+	case mode == task.StoppedGracefully:
+		return "Stopped on request, exited within its grace period"
+	case mode == task.StoppedKilled:
+		return fmt.Sprintf("Stopped on request, killed after its grace period of %v", t.GracePeriod())
+	}
+	return "Stopped on request"
+}
@@ -0,0 +1,99 @@
+package worker
+
+import (
+	"Mine-Cube/task"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types/volume"
+)
+
+var ErrVolumeNotManaged = errors.New("volume was not created for tasks")
+
+// bindAllowed reports whether path lies within one of the worker's allowed
+// host paths. Symlinks are resolved first, so that a link inside an allowed
+// path cannot lead outside of it.
+func (w *Worker) bindAllowed(path string) bool {
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+
+	for _, allowed := range w.AllowedBindPaths {
+		if resolved, err := filepath.EvalSymlinks(allowed); err == nil {
+			allowed = resolved
+		}
+		allowed = filepath.Clean(allowed)
+		if path == allowed || strings.HasPrefix(path, allowed+string(filepath.Separator)) || allowed == "/" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// prepareVolumes checks the task's bind mounts against the allow-list and
+// creates the named volumes it uses that do not exist on this worker yet.
+func (w *Worker) prepareVolumes(d *task.Docker, t *task.Task) error {
+	for _, v := range t.Volumes {
+		switch v.Type {
+		case task.VolumeBind:
+			if !filepath.IsAbs(v.Source) || !w.bindAllowed(v.Source) {
+				return fmt.Errorf("bind mount of %s is not allowed on this worker", v.Source)
+			}
+		case task.VolumeNamed:
+			labels := map[string]string{
+				task.NamespaceLabel:  t.Namespace,
+				task.VolumeNameLabel: v.Source,
+			}
+			if err := d.EnsureVolume(context.Background(), task.DockerVolumeName(t.Namespace, v.Source), labels); err != nil {
+				return fmt.Errorf("error creating volume %s: %w", v.Source, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetVolumes lists the named volumes on this worker.
+func (w *Worker) GetVolumes(ctx context.Context) ([]*volume.Volume, error) {
+	d := task.NewDocker(task.Config{})
+	if d == nil {
+		return nil, errors.New("failed to create Docker client")
+	}
+
+	return d.ListVolumes(ctx, task.VolumeNameLabel)
+}
+
+// GetVolume returns a named volume created for tasks. Other volumes on the
+// host are not the worker's to manage, and give ErrVolumeNotManaged.
+func (w *Worker) GetVolume(ctx context.Context, name string) (*volume.Volume, error) {
+	d := task.NewDocker(task.Config{})
+	if d == nil {
+		return nil, errors.New("failed to create Docker client")
+	}
+
+	v, err := d.InspectVolume(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := v.Labels[task.VolumeNameLabel]; !ok {
+		return nil, ErrVolumeNotManaged
+	}
+
+	return &v, nil
+}
+
+// RemoveVolume deletes a named volume and its data. Docker refuses while a
+// container still uses it.
+func (w *Worker) RemoveVolume(ctx context.Context, name string) error {
+	d := task.NewDocker(task.Config{})
+	if d == nil {
+		return errors.New("failed to create Docker client")
+	}
+
+	return d.RemoveVolume(ctx, name)
+}
@@ -3,8 +3,11 @@ package worker
 import (
 	"Mine-Cube/logger"
 	"Mine-Cube/task"
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"sync"
 	"time"
 
 	"github.com/golang-collections/collections/queue"
@@ -23,6 +26,22 @@ type Worker struct {
 	Db        map[uuid.UUID]*task.Task
 	TaskCount int
 	Stats     *Stats
+	// Logs keeps task output on disk after containers are removed
+	Logs *LogStore
+	// SecretsDir holds the secret files mounted into task containers
+	SecretsDir string
+	// ConfigsDir holds the config files mounted into task containers
+	ConfigsDir string
+	// AllowedBindPaths are the host paths tasks may bind mount
+	AllowedBindPaths []string
+
+	// mu guards Db, secrets and configs, which the API and the worker's
+	// loops all touch. It is never held while talking to Docker.
+	mu sync.Mutex
+	// secrets and configs delivered with tasks that have not been started
+	// yet
+	secrets map[uuid.UUID]map[string][]byte
+	configs map[uuid.UUID]map[string][]byte
 }
 
 func (w *Worker) CollectStats() {
@@ -37,30 +56,68 @@ func (w *Worker) CollectStats() {
 }
 
 func (w *Worker) updateTasks() {
-	for id, t := range w.Db {
-		if t.State == task.Running {
-			resp := w.InspectTask(*t)
+	for _, t := range w.GetTasks() {
+		if t.State == task.Running || t.State == task.Paused {
+			id := t.ID
+			resp := w.InspectTask(t)
 			if resp.Error != nil {
 				log.WithField("task_id", id).Errorf("Error inspecting container: %v", resp.Error)
 			}
 
+			w.mu.Lock()
+			// the task may have been stopped or restarted while its
+			// container was inspected
+			current, ok := w.Db[id]
+			if !ok || current.ContainerID != t.ContainerID || (current.State != task.Running && current.State != task.Paused) {
+				w.mu.Unlock()
+				continue
+			}
+
+			ended := false
 			if resp.Container == nil {
 				log.WithField("task_id", id).Warn("No container found for running task, marking as failed")
-				w.Db[id].State = task.Failed
+				current.State = task.Failed
+				current.StateReason = "Container not found"
+				ended = true
 			}
 
-			if resp.Container != nil && resp.Container.State.Status == "exited" {
+			if resp.Container != nil && (resp.Container.State.Status == "exited" || resp.Container.State.Status == "dead") {
+				state := resp.Container.State
+				exit := task.NewExitStatus(state.ExitCode, state.OOMKilled, state.Error)
+
+				current.Exit = exit
+				current.StateReason = exit.Reason()
+				// Docker reports "0001-01-01T00:00:00Z" for a container it
+				// has no finish time for
+				if finishedAt, err := time.Parse(time.RFC3339Nano, state.FinishedAt); err == nil && !finishedAt.IsZero() {
+					current.FinishTime = finishedAt.UTC()
+				}
+
+				if exit.Success {
+					current.State = task.Completed
+				} else {
+					current.State = task.Failed
+				}
+
 				log.WithFields(map[string]interface{}{
-					"task_id": id,
-					"status":  resp.Container.State.Status,
-				}).Warn("Container exited, marking task as failed")
-				w.Db[id].State = task.Failed
+					"task_id":    id,
+					"status":     state.Status,
+					"exit_code":  exit.ExitCode,
+					"oom_killed": exit.OOMKilled,
+					"new_state":  current.State,
+				}).Warn("Container exited")
+				ended = true
 			}
 
 			if resp.Container != nil {
-				w.Db[id].HostPorts =
+				current.HostPorts =
 					resp.Container.NetworkSettings.NetworkSettingsBase.Ports
 			}
+			w.mu.Unlock()
+
+			if ended {
+				w.removeTaskFiles(id)
+			}
 		}
 	}
 }
@@ -74,12 +131,16 @@ func (w *Worker) runTask() task.DockerResult {
 	}
 
 	taskQueued := t.(task.Task)
-	taskPersisted := w.Db[taskQueued.ID]
 
-	if taskPersisted == nil {
-		taskPersisted = &taskQueued
-		w.Db[taskQueued.ID] = taskPersisted
+	w.mu.Lock()
+	if _, ok := w.Db[taskQueued.ID]; !ok {
+		queued := taskQueued
+		w.Db[taskQueued.ID] = &queued
 	}
+	// a copy, as the API may change the stored task meanwhile
+	persisted := *w.Db[taskQueued.ID]
+	w.mu.Unlock()
+	taskPersisted := &persisted
 
 	log.WithFields(map[string]interface{}{
 		"task_id":         taskQueued.ID,
@@ -94,6 +155,9 @@ func (w *Worker) runTask() task.DockerResult {
 		switch taskQueued.State {
 		case task.Scheduled:
 			result = w.StartTask(*taskPersisted)
+		case task.Restarting:
+			// the restarted task picks up any changes to its spec
+			result = w.RestartTask(*taskPersisted, taskQueued)
 		case task.Completed:
 			result = w.StopTask(*taskPersisted)
 		default:
@@ -114,10 +178,27 @@ func (w *Worker) runTask() task.DockerResult {
 
 func (w *Worker) StartTask(t task.Task) task.DockerResult {
 	t.StartTime = time.Now().UTC()
+	// A new container has not been probed yet, nor exited
+	t.Health = task.Health{}
+	t.Exit = nil
 
 	log.WithField("task_id", t.ID).Info("Starting task")
 
 	taskConfig := task.NewConfig(&t)
+
+	err := w.injectSecrets(&t, &taskConfig)
+	if err == nil {
+		err = w.injectConfigs(&t, &taskConfig)
+	}
+	if err != nil {
+		log.WithField("task_id", t.ID).Errorf("Failed to prepare task files: %v", err)
+		w.removeTaskFiles(t.ID)
+		t.State = task.Failed
+		t.StateReason = fmt.Sprintf("Failed to prepare task files: %v", err)
+		w.putTask(t)
+		return task.DockerResult{Error: err}
+	}
+
 	docker := task.NewDocker(taskConfig)
 
 	if docker == nil {
@@ -126,18 +207,43 @@ func (w *Worker) StartTask(t task.Task) task.DockerResult {
 		return task.DockerResult{Error: err}
 	}
 
+	if err := w.prepareVolumes(docker, &t); err != nil {
+		log.WithField("task_id", t.ID).Errorf("Failed to prepare volumes: %v", err)
+		w.removeTaskFiles(t.ID)
+		t.State = task.Failed
+		t.StateReason = fmt.Sprintf("Failed to prepare volumes: %v", err)
+		w.putTask(t)
+		return task.DockerResult{Error: err}
+	}
+
+	if err := w.prepareNetworks(docker, &t); err != nil {
+		log.WithField("task_id", t.ID).Errorf("Failed to prepare networks: %v", err)
+		w.removeTaskFiles(t.ID)
+		t.State = task.Failed
+		t.StateReason = fmt.Sprintf("Failed to prepare networks: %v", err)
+		w.putTask(t)
+		return task.DockerResult{Error: err}
+	}
+
 	result := docker.Run()
 
 	if result.Error != nil {
 		log.WithField("task_id", t.ID).Errorf("Failed to run task: %v", result.Error)
+		w.removeTaskFiles(t.ID)
 		t.State = task.Failed
-		w.Db[t.ID] = &t
+		t.StateReason = fmt.Sprintf("Failed to run container: %v", result.Error)
+		w.putTask(t)
 		return result
 	}
 
 	t.ContainerID = result.ContainerId
 	t.State = task.Running
-	w.Db[t.ID] = &t
+	t.StateReason = "Container started"
+	w.putTask(t)
+
+	if w.Logs != nil {
+		go w.captureLogs(t)
+	}
 
 	log.WithFields(map[string]interface{}{
 		"task_id":      t.ID,
@@ -147,12 +253,133 @@ func (w *Worker) StartTask(t task.Task) task.DockerResult {
 	return result
 }
 
+// RestartTask replaces the task's container with a new one started from
+// spec, which may differ from the running task, for example in the config
+// versions it mounts.
+func (w *Worker) RestartTask(t task.Task, spec task.Task) task.DockerResult {
+	log.WithFields(map[string]interface{}{
+		"task_id":      t.ID,
+		"container_id": t.ContainerID,
+	}).Info("Restarting task")
+
+	w.setState(t.ID, task.Restarting, "Restart requested")
+
+	if t.ContainerID != "" {
+		docker := task.NewDocker(task.NewConfig(&t))
+		if docker == nil {
+			err := errors.New("failed to create Docker client")
+			log.WithField("task_id", t.ID).Errorf("Failed to create Docker client: %v", err)
+			return task.DockerResult{Error: err}
+		}
+
+		if t.State == task.Running {
+			w.runPreStop(t)
+		}
+
+		// the container may already be gone, which is fine
+		if result := docker.Stop(t.ContainerID); result.Error != nil {
+			log.WithField("container_id", t.ContainerID).Warnf("Error stopping container for restart: %v", result.Error)
+		}
+	}
+
+	spec.ContainerID = ""
+	return w.StartTask(spec)
+}
+
+// UpdateTask applies the resource limits and restart policy of spec to the
+// task's running container. Changes to anything else need a restart.
+func (w *Worker) UpdateTask(t task.Task, spec task.Task) task.DockerResult {
+	docker := task.NewDocker(task.NewConfig(&spec))
+	if docker == nil {
+		err := errors.New("failed to create Docker client")
+		log.WithField("task_id", t.ID).Errorf("Failed to create Docker client: %v", err)
+		return task.DockerResult{Error: err}
+	}
+
+	result := docker.Update(t.ContainerID)
+	if result.Error != nil {
+		return result
+	}
+
+	w.mu.Lock()
+	if current, ok := w.Db[t.ID]; ok {
+		current.Cpu = spec.Cpu
+		current.Memory = spec.Memory
+		current.RestartPolicy = spec.RestartPolicy
+		current.Revision = spec.Revision
+	}
+	w.mu.Unlock()
+	log.WithField("task_id", t.ID).Info("Task updated in place")
+
+	return result
+}
+
+// PauseTask freezes the processes of the task's container, which keeps its
+// memory and resources until the task is resumed or stopped.
+func (w *Worker) PauseTask(t task.Task) task.DockerResult {
+	docker := task.NewDocker(task.NewConfig(&t))
+	if docker == nil {
+		err := errors.New("failed to create Docker client")
+		log.WithField("task_id", t.ID).Errorf("Failed to create Docker client: %v", err)
+		return task.DockerResult{Error: err}
+	}
+
+	result := docker.Pause(t.ContainerID)
+	if result.Error != nil {
+		return result
+	}
+
+	w.setState(t.ID, task.Paused, "Paused on request")
+	log.WithField("task_id", t.ID).Info("Task paused")
+
+	return result
+}
+
+// ResumeTask lets a paused task's processes run again.
+func (w *Worker) ResumeTask(t task.Task) task.DockerResult {
+	docker := task.NewDocker(task.NewConfig(&t))
+	if docker == nil {
+		err := errors.New("failed to create Docker client")
+		log.WithField("task_id", t.ID).Errorf("Failed to create Docker client: %v", err)
+		return task.DockerResult{Error: err}
+	}
+
+	result := docker.Unpause(t.ContainerID)
+	if result.Error != nil {
+		return result
+	}
+
+	w.setState(t.ID, task.Running, "Resumed on request")
+	log.WithField("task_id", t.ID).Info("Task resumed")
+
+	return result
+}
+
+// StopTask runs the task's pre-stop hook, then sends its stop signal and
+// removes the container once it has exited or its grace period is over.
 func (w *Worker) StopTask(t task.Task) task.DockerResult {
+	return w.stopTask(t, false)
+}
+
+// KillTask removes the task's container right away with SIGKILL, skipping
+// its pre-stop hook and grace period.
+func (w *Worker) KillTask(t task.Task) task.DockerResult {
+	return w.stopTask(t, true)
+}
+
+func (w *Worker) stopTask(t task.Task, force bool) task.DockerResult {
 	log.WithFields(map[string]interface{}{
 		"task_id":      t.ID,
 		"container_id": t.ContainerID,
+		"force":        force,
 	}).Info("Stopping task")
 
+	if force {
+		w.setState(t.ID, task.Stopping, "Kill requested")
+	} else {
+		w.setState(t.ID, task.Stopping, "Stop requested")
+	}
+
 	taskConfig := task.NewConfig(&t)
 	docker := task.NewDocker(taskConfig)
 
@@ -162,28 +389,102 @@ func (w *Worker) StopTask(t task.Task) task.DockerResult {
 		return task.DockerResult{Error: err}
 	}
 
-	result := docker.Stop(t.ContainerID)
+	var result task.DockerResult
+	if force {
+		result = docker.Kill(t.ContainerID)
+	} else {
+		if t.State == task.Running {
+			w.runPreStop(t)
+		}
+		result = docker.Stop(t.ContainerID)
+	}
 	if result.Error != nil {
 		log.WithField("container_id", t.ContainerID).Errorf("Error stopping container: %v", result.Error)
 	}
 
 	t.FinishTime = time.Now().UTC()
 	t.State = task.Completed
+	t.StateReason = stopReason(t, force, result.StopMode)
+	t.StopMode = result.StopMode
+	if result.Exit != nil {
+		t.Exit = result.Exit
+	}
+
+	// a kill may have cut this stop short and already recorded the task
+	// as stopped
+	w.mu.Lock()
+	if current, ok := w.Db[t.ID]; ok && current.State == task.Completed {
+		w.mu.Unlock()
+		return result
+	}
 	w.Db[t.ID] = &t
+	w.mu.Unlock()
+
+	w.removeTaskFiles(t.ID)
 
 	log.WithFields(map[string]interface{}{
 		"task_id":      t.ID,
 		"container_id": t.ContainerID,
+		"stop_mode":    result.StopMode,
 	}).Info("Task stopped and removed successfully")
 
 	return result
 }
 
+// setState records a state the task is passing through while the worker
+// acts on it, so that it is reported if the manager asks meanwhile.
+func (w *Worker) setState(id uuid.UUID, state task.State, reason string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if t, ok := w.Db[id]; ok {
+		t.State = state
+		t.StateReason = reason
+	}
+}
+
+// removeTaskFiles deletes the secret and config files of a task.
+func (w *Worker) removeTaskFiles(id uuid.UUID) {
+	w.removeSecrets(id)
+	w.removeConfigs(id)
+}
+
 func (w *Worker) AddTask(t task.Task) {
 	w.Queue.Enqueue(t)
 }
 
+// putTask stores t as the task's current state.
+func (w *Worker) putTask(t task.Task) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.Db[t.ID] = &t
+}
+
+// GetTask returns a copy of a task, which stays the same while the worker
+// acts on the task.
+func (w *Worker) GetTask(id uuid.UUID) (task.Task, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	t, ok := w.Db[id]
+	if !ok {
+		return task.Task{}, false
+	}
+	return *t, true
+}
+
+func (w *Worker) taskCount() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return len(w.Db)
+}
+
 func (w *Worker) GetTasks() []task.Task {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
 	tasks := make([]task.Task, 0, len(w.Db))
 
 	for _, t := range w.Db {
@@ -194,11 +495,13 @@ func (w *Worker) GetTasks() []task.Task {
 }
 
 func (w *Worker) RunTasks() {
+	var prunedAt time.Time
+
 	for {
 		log.WithFields(map[string]interface{}{
 			"interval":    RUN_TASKS_INTERVAL,
 			"queue_len":   w.Queue.Len(),
-			"task_count":  len(w.Db),
+			"task_count":  w.taskCount(),
 		}).Debug("Processing task queue")
 
 		if w.Queue.Len() > 0 {
@@ -211,6 +514,13 @@ func (w *Worker) RunTasks() {
 			log.Debug("No tasks to process currently")
 		}
 
+		// networks are pruned here rather than on their own goroutine so
+		// that a starting task never loses the network it is joining
+		if time.Since(prunedAt) >= PRUNE_NETWORKS_INTERVAL {
+			w.pruneNetworks()
+			prunedAt = time.Now()
+		}
+
 		time.Sleep(RUN_TASKS_INTERVAL)
 	}
 }
@@ -221,11 +531,44 @@ func (w *Worker) InspectTask(t task.Task) task.DockerInspectResponse {
 	return d.Inspect(t.ContainerID)
 }
 
+func (w *Worker) TaskLogs(ctx context.Context, t task.Task, opts task.LogOptions) (io.ReadCloser, error) {
+	config := task.NewConfig(&t)
+	d := task.NewDocker(config)
+
+	if d == nil {
+		return nil, errors.New("failed to create Docker client")
+	}
+
+	return d.Logs(ctx, t.ContainerID, opts)
+}
+
+func (w *Worker) ExecTask(ctx context.Context, t task.Task, opts task.ExecOptions) (task.ExecResult, error) {
+	config := task.NewConfig(&t)
+	d := task.NewDocker(config)
+
+	if d == nil {
+		return task.ExecResult{}, errors.New("failed to create Docker client")
+	}
+
+	return d.Exec(ctx, t.ContainerID, opts)
+}
+
+func (w *Worker) AttachExec(ctx context.Context, t task.Task, opts task.ExecOptions) (*task.ExecSession, error) {
+	config := task.NewConfig(&t)
+	d := task.NewDocker(config)
+
+	if d == nil {
+		return nil, errors.New("failed to create Docker client")
+	}
+
+	return d.ExecAttach(ctx, t.ContainerID, opts)
+}
+
 func (w *Worker) UpdateTasks() {
 	for {
 		log.WithFields(map[string]interface{}{
 			"interval":   UPDATE_TASKS_INTERVAL,
-			"task_count": len(w.Db),
+			"task_count": w.taskCount(),
 		}).Debug("Checking status of tasks")
 
 		w.updateTasks()